@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Luzifer/ots/pkg/metrics"
+)
+
+// newAdminRouter creates the router for the admin listener: It serves
+// the metrics, the profiling endpoints and the health checks and must
+// not be reachable for the public.
+func newAdminRouter(api *apiServer) *mux.Router {
+	r := mux.NewRouter()
+
+	api.RegisterHealth(r)
+
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Index also serves the named profiles (i.e. heap, goroutine)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+
+	return r
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminRouter(t *testing.T) {
+	api, _ := newTestAPI(t)
+	oldAdminListen := cfg.AdminListen
+	t.Cleanup(func() { cfg.AdminListen = oldAdminListen })
+
+	cfg.AdminListen = "127.0.0.1:0"
+	cfg.StorageType = "mem"
+
+	get := func(r http.Handler, path string) int {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequestWithContext(context.Background(), http.MethodGet, path, nil))
+		return res.Code
+	}
+
+	public := mux.NewRouter()
+	api.Register(public.PathPrefix("/api").Subrouter())
+	for _, path := range []string{"/api/healthz", "/api/livez", "/api/readyz"} {
+		assert.Equal(t, http.StatusNotFound, get(public, path), path)
+	}
+
+	admin := newAdminRouter(api)
+	for _, path := range []string{"/healthz", "/livez", "/readyz", "/metrics", "/debug/pprof/", "/debug/pprof/heap"} {
+		assert.Equal(t, http.StatusOK, get(admin, path), path)
+	}
+	assert.Equal(t, http.StatusNotFound, get(admin, "/api/create"))
+}
@@ -2,8 +2,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,52 +17,173 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/Luzifer/ots/pkg/metrics"
+	"github.com/Luzifer/ots/pkg/ratelimit"
 	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/webhook"
 )
 
 const (
-	errorReasonInvalidExpiry  = "invalid_expiry"
-	errorReasonInvalidJSON    = "invalid_json"
-	errorReasonSecretMissing  = "secret_missing"
-	errorReasonSecretNotFound = "secret_not_found"
-	errorReasonSecretSize     = "secret_size"
-	errorReasonStorageError   = "storage_error"
+	errorReasonClientBanned    = "client_banned"
+	errorReasonInvalidCallback = "invalid_callback_url"
+	errorReasonInvalidExpiry   = "invalid_expiry"
+	errorReasonInvalidJSON     = "invalid_json"
+	errorReasonInvalidNonce    = "invalid_reveal_nonce"
+	errorReasonInvalidPIN      = "invalid_pin"
+	errorReasonInvalidToken    = "invalid_token"
+	errorReasonInvalidViews    = "invalid_max_views"
+	errorReasonNotAllowed      = "not_allowed"
+	errorReasonPINRequired     = "pin_required"
+	errorReasonRateLimited     = "rate_limited"
+	errorReasonSecretMissing   = "secret_missing"
+	errorReasonSecretNotFound  = "secret_not_found"
+	errorReasonSecretSize      = "secret_size"
+	errorReasonStorageError    = "storage_error"
+	errorReasonStorageTimeout  = "storage_timeout"
+
+	// routeStatusEvents names the route of the status event stream
+	// which must be served without buffering handlers
+	routeStatusEvents = "statusEvents"
+
+	statusExpired = "expired"
+	statusPending = "pending"
+	statusRead    = "read"
+
+	// Event streams poll the storage for status changes in this
+	// interval as not all storages are able to notify about changes
+	statusPollInterval = 2 * time.Second
+
+	// Reveal nonces are only valid for this duration after fetching
+	// the metadata of the secret
+	revealNonceTTL = 5 * time.Minute
+
+	// Secrets having a callback are kept at least this long after
+	// their content expired to give the storage time to report them
+	webhookExpiryGrace = time.Hour
 
 	maxExpirySeconds = int64(1<<63-1) / int64(time.Second)
+
+	// bcrypt only uses the first 72 bytes of the password
+	maxPINLength = 72
+	minPINLength = 4
+
+	// Requests only carrying tokens are way smaller than this
+	maxTokenRequestSize = 1024
+	tokenLength         = 32
+)
+
+var (
+	errInvalidPIN   = errors.New("invalid pin")
+	errInvalidToken = errors.New("invalid token")
+	errPINRequired  = errors.New("pin required")
 )
 
 type apiServer struct {
 	collector *metrics.Collector
 	store     storage.Storage
+	webhooks  *webhook.Dispatcher
+
+	// stopping is closed when the server is shutting down
+	stopping chan struct{}
+
+	// Limiters and the guard are optional and must be set before
+	// registering the routes
+	createLimiter ratelimit.Limiter
+	readGuard     *ratelimit.Guard
+	readLimiter   ratelimit.Limiter
 }
 
 type apiResponse struct {
-	Success   bool       `json:"success"`
-	Error     string     `json:"error,omitempty"`
-	ExpiresAt *time.Time `json:"expires_at,omitempty"`
-	Secret    string     `json:"secret,omitempty"` //#nosec:G117 // This application works with secrets
-	SecretID  string     `json:"secret_id,omitempty"`
+	Success        bool       `json:"success"`
+	Error          string     `json:"error,omitempty"`
+	DeletionToken  string     `json:"deletion_token,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	PINRequired    bool       `json:"pin_required,omitempty"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+	RemainingViews *int       `json:"remaining_views,omitempty"`
+	RevealNonce    string     `json:"reveal_nonce,omitempty"`
+	Secret         string     `json:"secret,omitempty"` //#nosec:G117 // This application works with secrets
+	SecretID       string     `json:"secret_id,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	StatusToken    string     `json:"status_token,omitempty"`
 }
 
 type apiRequest struct {
-	Secret string `json:"secret"` //#nosec:G117 // This application works with secrets
+	CallbackURL string `json:"callback_url"`
+	MaxViews    int    `json:"max_views"`
+	PIN         string `json:"pin"`
+	Secret      string `json:"secret"` //#nosec:G117 // This application works with secrets
+}
+
+type apiDeleteRequest struct {
+	DeletionToken string `json:"deletion_token"`
 }
 
-func newAPI(s storage.Storage, c *metrics.Collector) *apiServer {
-	return &apiServer{
+type apiRevealRequest struct {
+	PIN         string `json:"pin"`
+	RevealNonce string `json:"reveal_nonce"`
+}
+
+func newAPI(s storage.Storage, c *metrics.Collector, w *webhook.Dispatcher) *apiServer {
+	a := &apiServer{
 		collector: c,
 		store:     s,
+		webhooks:  w,
+
+		stopping: make(chan struct{}),
+	}
+
+	if w != nil {
+		if n, ok := s.(storage.ExpiryNotifier); ok {
+			n.NotifyExpired(a.handleExpired)
+		} else {
+			logrus.Warn("storage does not report expired secrets, webhooks are only sent on read")
+		}
 	}
+
+	return a
 }
 
 func (a apiServer) Register(r *mux.Router) {
-	r.HandleFunc("/create", a.handleCreate)
-	r.HandleFunc("/get/{id}", a.handleRead)
-	r.HandleFunc("/isWritable", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	r.Use(a.authenticate)
+
+	var (
+		limitCreate = a.rateLimited(a.createLimiter, a.collector.CountSecretCreateError)
+		limitRead   = func(next http.HandlerFunc) http.HandlerFunc {
+			return a.guarded(a.rateLimited(a.readLimiter, a.collector.CountSecretReadError)(next))
+		}
+	)
+
+	r.HandleFunc("/create", limitCreate(a.handleCreate))
+	r.HandleFunc("/delete/{id}", a.handleDelete).Methods(http.MethodPost)
+	// Kept for older clients, the reveal flow is safe against clients
+	// fetching URLs for previews
+	r.HandleFunc("/get/{id}", limitRead(a.handleRead)).Methods(http.MethodGet)
+	r.HandleFunc("/isWritable", a.handleIsWritable)
+	r.HandleFunc("/reveal/{id}", limitRead(a.handleRevealInfo)).Methods(http.MethodGet)
+	r.HandleFunc("/reveal/{id}", limitRead(a.handleReveal)).Methods(http.MethodPost)
 	r.HandleFunc("/settings", a.handleSettings).Methods(http.MethodGet)
-	r.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.HandleFunc("/status/{id}", a.handleStatus).Methods(http.MethodGet)
+	r.HandleFunc("/status/{id}/events", a.handleStatusEvents).Methods(http.MethodGet).Name(routeStatusEvents)
+
+	if cfg.AdminListen == "" {
+		a.RegisterHealth(r)
+	}
+}
+
+// RegisterHealth adds the health endpoints to the router, they are
+// served by the admin listener when configured
+func (a apiServer) RegisterHealth(r *mux.Router) {
+	// Kept for older setups, checks the readiness
+	r.HandleFunc("/healthz", a.handleReadiness).Methods(http.MethodGet)
+	r.HandleFunc("/livez", a.handleLiveness).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", a.handleReadiness).Methods(http.MethodGet)
 }
 
+// beginShutdown marks the server unhealthy and ends the event streams
+// in order to let the in-flight requests be drained. It must only be
+// called once.
+func (a apiServer) beginShutdown() { close(a.stopping) }
+
 func (a apiServer) errorResponse(res http.ResponseWriter, status int, err error, desc string) {
 	errID := uuid.Must(uuid.NewV4()).String()
 
@@ -74,16 +198,36 @@ func (a apiServer) errorResponse(res http.ResponseWriter, status int, err error,
 }
 
 func (a apiServer) handleCreate(res http.ResponseWriter, r *http.Request) {
-	if cust.MaxSecretSize > 0 {
+	if r.Method != http.MethodPost && authConfigured() {
+		// Browsers send cookies and cached credentials along with
+		// cross-site navigations, other methods than POST would allow
+		// other sites to create secrets in the name of the user
+		a.collector.CountSecretCreateError(errorReasonNotAllowed)
+		res.Header().Set("Allow", http.MethodPost)
+		a.errorResponse(res, http.StatusMethodNotAllowed, errors.New("method not allowed"), "")
+		return
+	}
+
+	if !a.checkCreateAllowed(res, r) {
+		a.collector.CountSecretCreateError(errorReasonNotAllowed)
+		return
+	}
+
+	var (
+		p             = principalFromContext(r.Context())
+		maxSecretSize = p.maxSecretSize()
+	)
+
+	if maxSecretSize > 0 {
 		// As a safeguard against HUGE payloads behind a misconfigured
 		// proxy we take double the maximum secret size after which we
 		// just close the read and cut the connection to the sender.
-		r.Body = http.MaxBytesReader(res, r.Body, cust.MaxSecretSize*2)
+		r.Body = http.MaxBytesReader(res, r.Body, maxSecretSize*2)
 	}
 
 	var (
 		expiry = cfg.SecretExpiry
-		secret string
+		req    apiRequest
 	)
 
 	if !cust.DisableExpiryOverride {
@@ -94,10 +238,10 @@ func (a apiServer) handleCreate(res http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	expiry = p.limitExpiry(expiry)
 
 	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
-		tmp := apiRequest{}
-		if err := json.NewDecoder(r.Body).Decode(&tmp); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			if _, ok := err.(*http.MaxBytesError); ok {
 				a.collector.CountSecretCreateError(errorReasonSecretSize)
 				// We don't do an error response here as the MaxBytesReader
@@ -110,25 +254,107 @@ func (a apiServer) handleCreate(res http.ResponseWriter, r *http.Request) {
 			a.errorResponse(res, http.StatusBadRequest, err, "decoding request body")
 			return
 		}
-		secret = tmp.Secret
 	} else {
-		secret = r.FormValue("secret")
+		req.CallbackURL = r.FormValue("callback_url")
+		req.PIN = r.FormValue("pin")
+		req.Secret = r.FormValue("secret")
+		if v := r.FormValue("max_views"); v != "" {
+			var err error
+			if req.MaxViews, err = strconv.Atoi(v); err != nil {
+				a.collector.CountSecretCreateError(errorReasonInvalidViews)
+				a.errorResponse(res, http.StatusBadRequest, errors.New("invalid max_views"), "")
+				return
+			}
+		}
 	}
 
-	if secret == "" {
+	if req.Secret == "" {
 		a.collector.CountSecretCreateError(errorReasonSecretMissing)
 		a.errorResponse(res, http.StatusBadRequest, errors.New("secret missing"), "")
 		return
 	}
 
-	if cust.MaxSecretSize > 0 && len(secret) > int(cust.MaxSecretSize) {
+	if maxSecretSize > 0 && len(req.Secret) > int(maxSecretSize) {
 		a.collector.CountSecretCreateError(errorReasonSecretSize)
 		a.errorResponse(res, http.StatusBadRequest, errors.New("secret size exceeds maximum"), "")
 		return
 	}
 
-	id, err := a.store.Create(secret, time.Duration(expiry)*time.Second)
+	if req.MaxViews < 0 || req.MaxViews > max(cust.MaxSecretViews, 1) {
+		a.collector.CountSecretCreateError(errorReasonInvalidViews)
+		a.errorResponse(res, http.StatusBadRequest, errors.New("max_views out of range"), "")
+		return
+	}
+
+	if req.CallbackURL != "" && (a.webhooks == nil || !callbackURLAllowed(req.CallbackURL, cust.WebhookAllowedURLs)) {
+		a.collector.CountSecretCreateError(errorReasonInvalidCallback)
+		a.errorResponse(res, http.StatusBadRequest, errors.New("callback_url not allowed"), "")
+		return
+	}
+
+	if req.PIN != "" && (len(req.PIN) < minPINLength || len(req.PIN) > maxPINLength) {
+		a.collector.CountSecretCreateError(errorReasonInvalidPIN)
+		a.errorResponse(res, http.StatusBadRequest, errors.New("pin length out of range"), "")
+		return
+	}
+
+	deletionToken, deletionTokenHash, err := generateToken()
 	if err != nil {
+		a.collector.CountSecretCreateError(errorReasonStorageError)
+		a.errorResponse(res, http.StatusInternalServerError, err, "generating deletion token")
+		return
+	}
+
+	secret := storage.Secret{
+		CallbackURL:       req.CallbackURL,
+		DeletionTokenHash: deletionTokenHash,
+		RemainingViews:    max(req.MaxViews, 1),
+		Secret:            req.Secret,
+	}
+	expireIn := time.Duration(expiry) * time.Second
+
+	if req.PIN != "" {
+		if secret.PINHash, err = hashPIN(req.PIN); err != nil {
+			a.collector.CountSecretCreateError(errorReasonStorageError)
+			a.errorResponse(res, http.StatusInternalServerError, err, "hashing pin")
+			return
+		}
+	}
+
+	var (
+		retention   time.Duration
+		statusToken string
+	)
+	if cfg.StatusRetention > 0 {
+		if statusToken, secret.StatusTokenHash, err = generateToken(); err != nil {
+			a.collector.CountSecretCreateError(errorReasonStorageError)
+			a.errorResponse(res, http.StatusInternalServerError, err, "generating status token")
+			return
+		}
+		retention = cfg.StatusRetention
+	}
+	if secret.CallbackURL != "" {
+		retention = max(retention, webhookExpiryGrace)
+	}
+
+	if expireIn > 0 && retention > 0 {
+		// Keep the secret after its content expired to be able to
+		// report it as expired
+		secret.ContentExpiry = time.Now().Add(expireIn)
+		expireIn += retention
+	}
+
+	ctx, cancel := a.storageContext(r.Context())
+	defer cancel()
+
+	id, err := a.store.Create(ctx, secret, expireIn)
+	if err != nil {
+		if isTimeoutError(err) {
+			a.collector.CountSecretCreateError(errorReasonStorageTimeout)
+			a.errorResponse(res, http.StatusGatewayTimeout, err, "creating secret")
+			return
+		}
+
 		a.collector.CountSecretCreateError(errorReasonStorageError)
 		a.errorResponse(res, http.StatusInternalServerError, err, "creating secret")
 		return
@@ -139,16 +365,27 @@ func (a apiServer) handleCreate(res http.ResponseWriter, r *http.Request) {
 		expiresAt = func(v time.Time) *time.Time { return &v }(time.Now().UTC().Add(time.Duration(expiry) * time.Second))
 	}
 
+	if p.name != "" {
+		// Audit trail of the creator, the identity is never stored
+		// along with the secret
+		logrus.WithFields(logrus.Fields{
+			"principal": p.name,
+			"secret_id": id,
+		}).Info("secret created")
+	}
+
 	a.collector.CountSecretCreated()
-	go updateStoredSecretsCount(a.store, a.collector)
+	a.collector.AdjustSecretsCount(1)
 	a.jsonResponse(res, http.StatusCreated, apiResponse{
-		ExpiresAt: expiresAt,
-		Success:   true,
-		SecretID:  id,
+		DeletionToken: deletionToken,
+		ExpiresAt:     expiresAt,
+		Success:       true,
+		SecretID:      id,
+		StatusToken:   statusToken,
 	})
 }
 
-func (a apiServer) handleRead(res http.ResponseWriter, r *http.Request) {
+func (a apiServer) handleDelete(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	if id == "" {
@@ -156,27 +393,380 @@ func (a apiServer) handleRead(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	secret, err := a.store.ReadAndDestroy(id)
-	if err != nil {
+	r.Body = http.MaxBytesReader(res, r.Body, maxTokenRequestSize)
+
+	var req apiDeleteRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.collector.CountSecretDeleteError(errorReasonInvalidJSON)
+			a.errorResponse(res, http.StatusBadRequest, err, "")
+			return
+		}
+	} else {
+		req.DeletionToken = r.FormValue("deletion_token")
+	}
+
+	ctx, cancel := a.storageContext(r.Context())
+	defer cancel()
+
+	if _, err := a.store.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		if secret.IsTombstone() || secret.ContentExpired() {
+			// Only kept to report the status, for the creator it is gone
+			return storage.UpdateActionKeep, storage.ErrSecretNotFound
+		}
+		if !tokenMatchesHash(req.DeletionToken, secret.DeletionTokenHash) {
+			return storage.UpdateActionKeep, errInvalidToken
+		}
+		return storage.UpdateActionDelete, nil
+	}); err != nil {
 		status := http.StatusInternalServerError
-		if errors.Is(err, storage.ErrSecretNotFound) {
-			a.collector.CountSecretReadError(errorReasonSecretNotFound)
+		switch {
+		case errors.Is(err, errInvalidToken):
+			a.collector.CountSecretDeleteError(errorReasonInvalidToken)
+			status = http.StatusForbidden
+		case errors.Is(err, storage.ErrSecretNotFound):
+			a.collector.CountSecretDeleteError(errorReasonSecretNotFound)
 			status = http.StatusNotFound
-		} else {
-			a.collector.CountSecretReadError(errorReasonStorageError)
+		case isTimeoutError(err):
+			a.collector.CountSecretDeleteError(errorReasonStorageTimeout)
+			status = http.StatusGatewayTimeout
+		default:
+			a.collector.CountSecretDeleteError(errorReasonStorageError)
 		}
-		a.errorResponse(res, status, err, "reading & destroying secret")
+		a.errorResponse(res, status, err, "deleting secret")
 		return
 	}
 
-	a.collector.CountSecretRead()
-	go updateStoredSecretsCount(a.store, a.collector)
+	a.collector.CountSecretDeleted()
+	a.collector.AdjustSecretsCount(-1)
 	a.jsonResponse(res, http.StatusOK, apiResponse{
 		Success: true,
-		Secret:  secret,
 	})
 }
 
+func (a apiServer) handleRead(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		a.errorResponse(res, http.StatusBadRequest, errors.New("id missing"), "")
+		return
+	}
+
+	// The PIN cannot be passed to this route, therefore PIN protected
+	// secrets can only be revealed through the reveal flow
+	a.consumeSecret(res, r, id, "")
+}
+
+func (a apiServer) handleReveal(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		a.errorResponse(res, http.StatusBadRequest, errors.New("id missing"), "")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(res, r.Body, maxTokenRequestSize)
+
+	var req apiRevealRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.collector.CountSecretReadError(errorReasonInvalidJSON)
+			a.errorResponse(res, http.StatusBadRequest, err, "")
+			return
+		}
+	} else {
+		req.PIN = r.FormValue("pin")
+		req.RevealNonce = r.FormValue("reveal_nonce")
+	}
+
+	if !validRevealNonce(id, req.RevealNonce) {
+		a.collector.CountSecretReadError(errorReasonInvalidNonce)
+		a.errorResponse(res, http.StatusForbidden, errors.New("invalid reveal nonce"), "")
+		return
+	}
+
+	a.consumeSecret(res, r, id, req.PIN)
+}
+
+// handleRevealInfo returns the metadata of the secret together with
+// the nonce required to reveal it without consuming a view
+func (a apiServer) handleRevealInfo(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		a.errorResponse(res, http.StatusBadRequest, errors.New("id missing"), "")
+		return
+	}
+
+	ctx, cancel := a.storageContext(r.Context())
+	defer cancel()
+
+	secret, err := a.store.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		if secret.IsTombstone() || secret.ContentExpired() {
+			return storage.UpdateActionKeep, storage.ErrSecretNotFound
+		}
+		return storage.UpdateActionKeep, nil
+	})
+	if err != nil {
+		a.errorResponse(res, statusErrorCode(err), err, "reading secret metadata")
+		return
+	}
+
+	resp := apiResponse{
+		Success:        true,
+		PINRequired:    secret.PINHash != "",
+		RemainingViews: &secret.RemainingViews,
+		RevealNonce:    newRevealNonce(id),
+	}
+
+	switch {
+	case !secret.ContentExpiry.IsZero():
+		resp.ExpiresAt = timeRef(secret.ContentExpiry)
+	case !secret.Expiry.IsZero():
+		resp.ExpiresAt = timeRef(secret.Expiry)
+	}
+
+	a.jsonResponse(res, http.StatusOK, resp)
+}
+
+// consumeSecret reads the secret, consuming one of its views, after
+// checking the PIN of PIN protected secrets
+func (a apiServer) consumeSecret(res http.ResponseWriter, r *http.Request, id, pin string) {
+	ctx, cancel := a.storageContext(r.Context())
+	defer cancel()
+
+	err := a.checkPIN(ctx, id, pin)
+	if err == nil {
+		var secret storage.Secret
+		if secret, err = storage.ReadAndKeepTombstoneChecked(ctx, a.store, id, cfg.StatusRetention, releasePINAttempt); err == nil {
+			a.secretConsumed(res, id, secret)
+			return
+		}
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errPINRequired):
+		a.collector.CountSecretReadError(errorReasonPINRequired)
+		status = http.StatusForbidden
+	case errors.Is(err, errInvalidPIN):
+		a.collector.CountSecretReadError(errorReasonInvalidPIN)
+		status = http.StatusForbidden
+	case errors.Is(err, storage.ErrSecretNotFound):
+		a.collector.CountSecretReadError(errorReasonSecretNotFound)
+		status = http.StatusNotFound
+	case isTimeoutError(err):
+		a.collector.CountSecretReadError(errorReasonStorageTimeout)
+		status = http.StatusGatewayTimeout
+	default:
+		a.collector.CountSecretReadError(errorReasonStorageError)
+	}
+	a.errorResponse(res, status, err, "reading & destroying secret")
+}
+
+// checkPIN verifies the PIN of PIN protected secrets. The slow hash
+// is compared outside the update as the storage might call the
+// UpdateFunc multiple times, therefore the attempt is counted before
+// comparing: Concurrent guesses cannot exceed the allowed attempts.
+// The attempt of a matching PIN is released by releasePINAttempt when
+// consuming the secret, the secret is destroyed when the last allowed
+// attempt was wrong.
+func (a apiServer) checkPIN(ctx context.Context, id, pin string) error {
+	maxAttempts := pinMaxAttempts()
+
+	secret, err := a.store.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		switch {
+		case secret.IsTombstone() || secret.ContentExpired():
+			return storage.UpdateActionKeep, storage.ErrSecretNotFound
+
+		case secret.PINHash == "" || pin == "":
+			return storage.UpdateActionKeep, nil
+
+		case secret.PINAttempts >= maxAttempts:
+			// All attempts are taken by guesses still being compared
+			return storage.UpdateActionKeep, errInvalidPIN
+		}
+
+		secret.PINAttempts++
+		return storage.UpdateActionStore, nil
+	})
+	switch {
+	case err != nil:
+		return fmt.Errorf("fetching secret: %w", err)
+	case secret.PINHash == "":
+		return nil
+	case pin == "":
+		// Not counted as an attempt to not let clients unaware of the
+		// PIN destroy the secret
+		return errPINRequired
+	case pinMatchesHash(pin, secret.PINHash):
+		return nil
+	}
+
+	// The wrong attempt stays counted
+	var destroyed bool
+	if _, err = a.store.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		if destroyed = secret.PINAttempts >= maxAttempts; destroyed {
+			return storage.UpdateActionDelete, nil
+		}
+		return storage.UpdateActionKeep, nil
+	}); err != nil && !errors.Is(err, storage.ErrSecretNotFound) {
+		return fmt.Errorf("checking pin attempts: %w", err)
+	}
+
+	if destroyed {
+		logrus.WithField("secret_id", id).Warn("secret destroyed after too many wrong pins")
+		a.collector.AdjustSecretsCount(-1)
+	}
+
+	return errInvalidPIN
+}
+
+// releasePINAttempt releases the attempt counted by checkPIN for the
+// matching PIN while consuming the secret. The read is refused when
+// the stored attempts do not allow it anymore.
+func releasePINAttempt(secret *storage.Secret) error {
+	if secret.PINHash == "" {
+		return nil
+	}
+
+	secret.PINAttempts = max(secret.PINAttempts-1, 0)
+	if secret.PINAttempts >= pinMaxAttempts() {
+		return errInvalidPIN
+	}
+
+	return nil
+}
+
+// pinMaxAttempts returns the number of allowed PIN attempts, at least
+// one attempt is required to read the secret at all
+func pinMaxAttempts() int { return max(cfg.PINMaxAttempts, 1) }
+
+// secretConsumed sends the response and events for a consumed secret
+func (a apiServer) secretConsumed(res http.ResponseWriter, id string, secret storage.Secret) {
+	a.collector.CountSecretRead()
+	if secret.RemainingViews == 0 && !secret.IsTombstone() {
+		a.collector.AdjustSecretsCount(-1)
+	}
+
+	if secret.CallbackURL != "" && a.webhooks != nil {
+		a.webhooks.Send(secret.CallbackURL, webhook.Event{
+			Event:          webhook.EventSecretRead,
+			SecretID:       id,
+			RemainingViews: &secret.RemainingViews,
+		})
+	}
+
+	a.jsonResponse(res, http.StatusOK, apiResponse{
+		Success:        true,
+		RemainingViews: &secret.RemainingViews,
+		Secret:         secret.Secret,
+	})
+}
+
+// handleExpired is called by the storage for secrets having a callback
+// whose content expired unread
+func (a apiServer) handleExpired(id string, secret storage.Secret) {
+	a.webhooks.Send(secret.CallbackURL, webhook.Event{
+		Event:    webhook.EventSecretExpired,
+		SecretID: id,
+	})
+}
+
+func (a apiServer) handleStatus(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		a.errorResponse(res, http.StatusBadRequest, errors.New("id missing"), "")
+		return
+	}
+
+	status, err := a.readStatus(r.Context(), id, statusTokenFromRequest(r))
+	if err != nil {
+		a.errorResponse(res, statusErrorCode(err), err, "reading secret status")
+		return
+	}
+
+	a.jsonResponse(res, http.StatusOK, status)
+}
+
+func (a apiServer) handleStatusEvents(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		a.errorResponse(res, http.StatusBadRequest, errors.New("id missing"), "")
+		return
+	}
+
+	token := statusTokenFromRequest(r)
+
+	status, err := a.readStatus(r.Context(), id, token)
+	if err != nil {
+		a.errorResponse(res, statusErrorCode(err), err, "reading secret status")
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-store, max-age=0")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	var (
+		logger = logrus.WithField("secret_id", id)
+		rc     = http.NewResponseController(res)
+		ticker = time.NewTicker(statusPollInterval)
+	)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		data, err := json.Marshal(status)
+		if err != nil {
+			logger.WithError(err).Error("encoding status event")
+			return
+		}
+
+		// Only changes are sent to the client, the stream is polled
+		// way more often than the status changes
+		if !bytes.Equal(data, last) {
+			if _, err = fmt.Fprintf(res, "event: status\ndata: %s\n\n", data); err != nil {
+				logger.WithError(err).Debug("writing status event")
+				return
+			}
+
+			if err = rc.Flush(); err != nil {
+				// Without flushing the events would never reach the client
+				// so we stop after the current status
+				logger.WithError(err).Debug("flushing status event")
+				return
+			}
+
+			last = data
+		}
+
+		if status.Status != statusPending {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-a.stopping:
+			// The client will reconnect to another instance
+			return
+
+		case <-ticker.C:
+		}
+
+		if status, err = a.readStatus(r.Context(), id, token); err != nil {
+			// The client will reconnect and receive a proper error
+			logger.WithError(err).Debug("reading secret status for event stream")
+			return
+		}
+	}
+}
+
 func (a apiServer) handleSettings(w http.ResponseWriter, _ *http.Request) {
 	a.jsonResponse(w, http.StatusOK, cust)
 }
@@ -192,6 +782,54 @@ func (apiServer) jsonResponse(res http.ResponseWriter, status int, response any)
 	}
 }
 
+// readStatus fetches the status of the secret without altering it
+// after checking the status token
+func (a apiServer) readStatus(ctx context.Context, id, token string) (apiResponse, error) {
+	ctx, cancel := a.storageContext(ctx)
+	defer cancel()
+
+	secret, err := a.store.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		if !tokenMatchesHash(token, secret.StatusTokenHash) {
+			return storage.UpdateActionKeep, errInvalidToken
+		}
+		return storage.UpdateActionKeep, nil
+	})
+	if err != nil {
+		return apiResponse{}, fmt.Errorf("fetching secret: %w", err)
+	}
+
+	resp := apiResponse{Success: true}
+
+	switch {
+	case secret.IsTombstone():
+		resp.Status = statusRead
+		resp.ReadAt = timeRef(secret.ReadAt)
+
+	case secret.ContentExpired():
+		resp.Status = statusExpired
+		resp.ExpiresAt = timeRef(secret.ContentExpiry)
+
+	default:
+		resp.Status = statusPending
+		resp.RemainingViews = &secret.RemainingViews
+		if !secret.ContentExpiry.IsZero() {
+			resp.ExpiresAt = timeRef(secret.ContentExpiry)
+		}
+	}
+
+	return resp, nil
+}
+
+// storageContext derives the context for a storage operation from the
+// request context applying the configured storage timeout
+func (apiServer) storageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cfg.StorageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, cfg.StorageTimeout)
+}
+
 func (apiServer) parseExpiryOverride(r *http.Request, expiry int64) (int64, error) {
 	expiryValues, ok := r.URL.Query()["expire"]
 	if !ok {
@@ -5,12 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -18,6 +24,7 @@ import (
 	"github.com/Luzifer/ots/pkg/metrics"
 	"github.com/Luzifer/ots/pkg/storage"
 	"github.com/Luzifer/ots/pkg/storage/memory"
+	"github.com/Luzifer/ots/pkg/webhook"
 )
 
 var testCollector = metrics.New()
@@ -120,13 +127,539 @@ func TestHandleCreateExpiryOverrideValidation(t *testing.T) {
 
 			require.Equal(t, http.StatusBadRequest, res.Code)
 
-			count, err := store.Count()
+			count, err := store.Count(context.Background())
 			require.NoError(t, err)
 			assert.Zero(t, count)
 		})
 	}
 }
 
+func TestHandleDelete(t *testing.T) {
+	api, store := newTestAPI(t)
+
+	res := createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	require.NotEmpty(t, created.DeletionToken)
+
+	deleteSecret := func(id, body string) int {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/delete/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		res := httptest.NewRecorder()
+		api.handleDelete(res, req)
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, deleteSecret(created.SecretID, `{"deletion_token":"wrong"}`))
+	assert.Equal(t, http.StatusForbidden, deleteSecret(created.SecretID, `{}`))
+
+	count, err := store.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "invalid token must not destroy the secret")
+
+	assert.Equal(t, http.StatusOK, deleteSecret(created.SecretID, `{"deletion_token":"`+created.DeletionToken+`"}`))
+	assert.Equal(t, http.StatusNotFound, deleteSecret(created.SecretID, `{"deletion_token":"`+created.DeletionToken+`"}`))
+	assert.Equal(t, http.StatusNotFound, readSecret(api, created.SecretID).Code)
+}
+
+func TestHandleMultiViewSecret(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	var err error
+	cust, err = customization.Load("")
+	require.NoError(t, err)
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader(`{"secret":"test-secret","max_views":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	api.handleCreate(res, req)
+	require.Equal(t, http.StatusBadRequest, res.Code, "multi-view secrets must be disabled by default")
+
+	cust.MaxSecretViews = 2
+
+	req = httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader(`{"secret":"test-secret","max_views":3}`))
+	req.Header.Set("Content-Type", "application/json")
+	res = httptest.NewRecorder()
+	api.handleCreate(res, req)
+	require.Equal(t, http.StatusBadRequest, res.Code, "views above the limit must be rejected")
+
+	req = httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader("secret=test-secret&max_views=2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	api.handleCreate(res, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	for _, wantRemaining := range []int{1, 0} {
+		res = readSecret(api, created.SecretID)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		var read apiResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&read))
+		assert.Equal(t, "test-secret", read.Secret)
+		require.NotNil(t, read.RemainingViews)
+		assert.Equal(t, wantRemaining, *read.RemainingViews)
+	}
+
+	assert.Equal(t, http.StatusNotFound, readSecret(api, created.SecretID).Code)
+}
+
+func TestHandleReveal(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	do := func(method, target, nonce string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if nonce != "" {
+			body = strings.NewReader(url.Values{"reveal_nonce": {nonce}}.Encode())
+		}
+
+		req := httptest.NewRequestWithContext(context.Background(), method, target, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+
+	res := createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	// The legacy route only consumes on GET, the metadata below proves
+	// the secret has not been consumed
+	for _, method := range []string{http.MethodHead, http.MethodPost, http.MethodOptions} {
+		assert.NotEqual(t, http.StatusOK, do(method, "/api/get/"+created.SecretID, "").Code, method)
+	}
+
+	// Fetching the metadata is possible multiple times
+	var info apiResponse
+	for range 2 {
+		res = do(http.MethodGet, "/api/reveal/"+created.SecretID, "")
+		require.Equal(t, http.StatusOK, res.Code)
+
+		info = apiResponse{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+		assert.NotEmpty(t, info.RevealNonce)
+		assert.NotNil(t, info.ExpiresAt)
+		require.NotNil(t, info.RemainingViews)
+		assert.Equal(t, 1, *info.RemainingViews)
+		assert.Empty(t, info.Secret, "metadata must not contain the content")
+	}
+
+	expired := "1." + revealNonceSignature(created.SecretID, "1")
+	for name, nonce := range map[string]string{
+		"missing":    "",
+		"garbage":    "foobar",
+		"expired":    expired,
+		"other-id":   newRevealNonce("c2f0c9b2-3c58-4d3b-8f0e-5b4e3a0d3e1f"),
+		"tampered":   info.RevealNonce + "x",
+		"no-expiry":  strings.SplitN(info.RevealNonce, ".", 2)[1],
+		"wrong-date": "9" + info.RevealNonce,
+	} {
+		assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/reveal/"+created.SecretID, nonce).Code, name)
+	}
+
+	res = do(http.MethodPost, "/api/reveal/"+created.SecretID, info.RevealNonce)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var read apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&read))
+	assert.Equal(t, "test-secret", read.Secret)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/reveal/"+created.SecretID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/reveal/"+created.SecretID, info.RevealNonce).Code)
+}
+
+func TestHandlePIN(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.PINMaxAttempts = 2
+
+	create := func(pin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader(url.Values{
+			"pin":    {pin},
+			"secret": {"test-secret"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res := httptest.NewRecorder()
+		api.handleCreate(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusBadRequest, create("123").Code, "too short pin must be rejected")
+
+	res := create("1234")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	secret, err := store.Update(context.Background(), created.SecretID, func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil })
+	require.NoError(t, err)
+	assert.NotContains(t, secret.PINHash, "1234", "pin must be stored hashed")
+
+	assert.Equal(t, http.StatusForbidden, readSecret(api, created.SecretID).Code, "legacy route must not reveal pin protected secrets")
+
+	res, info := revealSecret(t, api, created.SecretID, "")
+	assert.True(t, info.PINRequired)
+	assert.Equal(t, http.StatusForbidden, res.Code, "missing pin must be rejected")
+
+	res, _ = revealSecret(t, api, created.SecretID, "4321")
+	assert.Equal(t, http.StatusForbidden, res.Code, "wrong pin must be rejected")
+
+	res, _ = revealSecret(t, api, created.SecretID, "1234")
+	require.Equal(t, http.StatusOK, res.Code, "missing pins must not count as attempt")
+
+	var read apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&read))
+	assert.Equal(t, "test-secret", read.Secret)
+
+	// Too many wrong attempts destroy the secret
+	res = create("1234")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	created = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	for range cfg.PINMaxAttempts {
+		res, _ = revealSecret(t, api, created.SecretID, "4321")
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	}
+
+	res, _ = revealSecret(t, api, created.SecretID, "1234")
+	assert.Equal(t, http.StatusNotFound, res.Code, "secret must be destroyed after too many wrong pins")
+
+	// Attempts taken by concurrent guesses are respected
+	res = create("1234")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	created = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	setAttempts := func(attempts int) {
+		_, err := store.Update(context.Background(), created.SecretID, func(secret *storage.Secret) (storage.UpdateAction, error) {
+			secret.PINAttempts = attempts
+			return storage.UpdateActionStore, nil
+		})
+		require.NoError(t, err)
+	}
+
+	setAttempts(cfg.PINMaxAttempts)
+	res, _ = revealSecret(t, api, created.SecretID, "1234")
+	assert.Equal(t, http.StatusForbidden, res.Code, "pin must not be compared without free attempt")
+
+	setAttempts(cfg.PINMaxAttempts + 1)
+	assert.ErrorIs(t, api.checkPIN(context.Background(), created.SecretID, "1234"), errInvalidPIN)
+	_, err = storage.ReadAndKeepTombstoneChecked(context.Background(), store, created.SecretID, 0, releasePINAttempt)
+	assert.ErrorIs(t, err, errInvalidPIN, "consuming must be refused with too many attempts")
+
+	setAttempts(cfg.PINMaxAttempts - 1)
+	res, _ = revealSecret(t, api, created.SecretID, "1234")
+	require.Equal(t, http.StatusOK, res.Code, "last attempt must be usable")
+}
+
+func TestHandlePINConcurrent(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.PINMaxAttempts = 3
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader(url.Values{
+		"pin":    {"1234"},
+		"secret": {"test-secret"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	api.handleCreate(res, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	var (
+		compared atomic.Int32
+		wg       sync.WaitGroup
+	)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Refused guesses are wrapped into the fetch error
+			if err := api.checkPIN(context.Background(), created.SecretID, fmt.Sprintf("9%03d", i)); err == errInvalidPIN { //nolint:errorlint // Distinguishes compared guesses
+				compared.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err := store.Update(context.Background(), created.SecretID, func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil })
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "secret must be destroyed")
+	assert.Equal(t, int32(cfg.PINMaxAttempts), compared.Load(), "only the allowed attempts may be compared")
+}
+
+func TestHandleStatus(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.StatusRetention = time.Hour
+
+	res := createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	require.NotEmpty(t, created.StatusToken)
+
+	res = readStatus(api, created.SecretID, "wrong")
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = readStatus(api, created.SecretID, created.StatusToken)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var status apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	assert.Equal(t, statusPending, status.Status)
+	assert.NotNil(t, status.ExpiresAt)
+	assert.Nil(t, status.ReadAt)
+	assert.Empty(t, status.Secret, "status must not reveal the content")
+
+	require.Equal(t, http.StatusOK, readSecret(api, created.SecretID).Code)
+	assert.Equal(t, http.StatusNotFound, readSecret(api, created.SecretID).Code, "tombstone must not be readable")
+
+	res = readStatus(api, created.SecretID, created.StatusToken)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	status = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	assert.Equal(t, statusRead, status.Status)
+	require.NotNil(t, status.ReadAt)
+	assert.WithinDuration(t, time.Now(), *status.ReadAt, time.Second)
+	assert.Empty(t, status.Secret, "status must not reveal the content")
+
+	count, err := store.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "tombstone must be kept")
+
+	// Simulate the content having expired
+	res = createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	created = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	_, err = store.Update(context.Background(), created.SecretID, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.ContentExpiry = time.Now().Add(-time.Second)
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotFound, readSecret(api, created.SecretID).Code, "expired content must not be readable")
+
+	res = readStatus(api, created.SecretID, created.StatusToken)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	status = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	assert.Equal(t, statusExpired, status.Status)
+
+	assert.Equal(t, http.StatusNotFound, readStatus(api, "c2f0c9b2-3c58-4d3b-8f0e-5b4e3a0d3e1f", created.StatusToken).Code)
+}
+
+func TestHandleStatusDisabled(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.StatusRetention = 0
+
+	res := createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	assert.Empty(t, created.StatusToken)
+
+	require.Equal(t, http.StatusOK, readSecret(api, created.SecretID).Code)
+
+	count, err := store.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count, "no tombstone must be kept")
+}
+
+func TestHandleStatusEvents(t *testing.T) {
+	api, _ := newTestAPI(t)
+	cfg.StatusRetention = time.Hour
+
+	res := createJSONSecret(api, "/api/create")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	require.Equal(t, http.StatusOK, readSecret(api, created.SecretID).Code)
+
+	// The stream ends as soon as the final status has been sent
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/status/"+created.SecretID+"/events?token="+created.StatusToken, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": created.SecretID})
+	res = httptest.NewRecorder()
+	api.handleStatusEvents(res, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/event-stream", res.Header().Get("Content-Type"))
+	assert.True(t, res.Flushed)
+
+	event, data, ok := strings.Cut(strings.TrimSpace(res.Body.String()), "\n")
+	require.True(t, ok)
+	assert.Equal(t, "event: status", event)
+
+	var status apiResponse
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &status))
+	assert.Equal(t, statusRead, status.Status)
+}
+
+func TestHandleEventStreams(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	var wrapped bool
+	hdl := handleEventStreams(r, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		wrapped = true
+		r.ServeHTTP(res, req)
+	}))
+
+	for path, wantWrapped := range map[string]bool{
+		"/api/create":            true,
+		"/api/get/foo":           true,
+		"/api/status/foo":        true,
+		"/api/status/foo/events": false,
+	} {
+		wrapped = false
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, path, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		hdl.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, wantWrapped, wrapped, path)
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	const webhookSecret = "webhook-secret"
+
+	events := make(chan webhook.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if !webhook.Verify([]byte(webhookSecret), r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var ev webhook.Event
+		require.NoError(t, json.Unmarshal(body, &ev))
+		events <- ev
+	}))
+	t.Cleanup(srv.Close)
+
+	_, store := newTestAPI(t)
+	api := newAPI(store, testCollector, webhook.New(webhookSecret))
+	cust.WebhookAllowedURLs = []string{srv.URL + "/hooks"}
+
+	create := func(callbackURL string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(apiRequest{CallbackURL: callbackURL, Secret: "test-secret"})
+		require.NoError(t, err)
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		res := httptest.NewRecorder()
+		api.handleCreate(res, req)
+		return res
+	}
+
+	waitEvent := func() webhook.Event {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("webhook was not delivered")
+			return webhook.Event{}
+		}
+	}
+
+	assert.Equal(t, http.StatusBadRequest, create(srv.URL+"/other").Code, "callback outside allowlist must be rejected")
+
+	// Read event
+	res := create(srv.URL + "/hooks/read")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	require.Equal(t, http.StatusOK, readSecret(api, created.SecretID).Code)
+
+	ev := waitEvent()
+	assert.Equal(t, webhook.EventSecretRead, ev.Event)
+	assert.Equal(t, created.SecretID, ev.SecretID)
+	require.NotNil(t, ev.RemainingViews)
+	assert.Equal(t, 0, *ev.RemainingViews)
+
+	// Expiry event
+	res = create(srv.URL + "/hooks/expired")
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	created = apiResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	_, err := store.Update(context.Background(), created.SecretID, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		assert.True(t, secret.Expiry.After(secret.ContentExpiry), "secret must be kept to report its expiry")
+		secret.ContentExpiry = time.Now().Add(-time.Second)
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+
+	// Let the storage report the expiry as the pruner would
+	require.NoError(t, store.(interface {
+		NotifyIfExpired(context.Context, storage.Storage, string) error
+	}).NotifyIfExpired(context.Background(), store, created.SecretID))
+
+	ev = waitEvent()
+	assert.Equal(t, webhook.EventSecretExpired, ev.Event)
+	assert.Equal(t, created.SecretID, ev.SecretID)
+	assert.Nil(t, ev.RemainingViews)
+}
+
+func TestHandleWebhookDisabled(t *testing.T) {
+	api, _ := newTestAPI(t)
+	cust.WebhookAllowedURLs = []string{"https://example.com/"}
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/create", strings.NewReader(url.Values{
+		"callback_url": {"https://example.com/hook"},
+		"secret":       {"test-secret"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res := httptest.NewRecorder()
+	api.handleCreate(res, req)
+
+	assert.Equal(t, http.StatusBadRequest, res.Code, "callback must be rejected without webhook secret")
+}
+
+func TestHandleStorageTimeout(t *testing.T) {
+	api, _ := newTestAPI(t)
+	api.store = blockingStore{}
+	cfg.StorageTimeout = 10 * time.Millisecond
+
+	res := createJSONSecret(api, "/api/create")
+	assert.Equal(t, http.StatusGatewayTimeout, res.Code)
+
+	res = readSecret(api, "foo")
+	assert.Equal(t, http.StatusGatewayTimeout, res.Code)
+}
+
 func createJSONSecret(api *apiServer, target string) *httptest.ResponseRecorder {
 	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewBufferString(`{"secret":"test-secret"}`))
 	req.Header.Set("Content-Type", "application/json")
@@ -137,6 +670,77 @@ func createJSONSecret(api *apiServer, target string) *httptest.ResponseRecorder
 	return res
 }
 
+func readSecret(api *apiServer, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/get/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	res := httptest.NewRecorder()
+	api.handleRead(res, req)
+
+	return res
+}
+
+// revealSecret executes the reveal flow and returns the response of the
+// reveal and the metadata. If fetching the metadata fails its response
+// is returned.
+func revealSecret(t *testing.T, api *apiServer, id, pin string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/reveal/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	res := httptest.NewRecorder()
+	api.handleRevealInfo(res, req)
+	if res.Code != http.StatusOK {
+		return res, apiResponse{}
+	}
+
+	var info apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+
+	req = httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/api/reveal/"+id, strings.NewReader(url.Values{
+		"pin":          {pin},
+		"reveal_nonce": {info.RevealNonce},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	res = httptest.NewRecorder()
+	api.handleReveal(res, req)
+
+	return res, info
+}
+
+func readStatus(api *apiServer, id, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/status/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	res := httptest.NewRecorder()
+	api.handleStatus(res, req)
+
+	return res
+}
+
+// blockingStore simulates a backend which never answers and only
+// returns when the context is done
+type blockingStore struct{}
+
+func (blockingStore) Count(ctx context.Context) (int64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (blockingStore) Create(ctx context.Context, _ storage.Secret, _ time.Duration) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (blockingStore) Update(ctx context.Context, _ string, _ storage.UpdateFunc) (storage.Secret, error) {
+	<-ctx.Done()
+	return storage.Secret{}, ctx.Err()
+}
+
 func newTestAPI(t *testing.T) (*apiServer, storage.Storage) {
 	t.Helper()
 
@@ -151,5 +755,5 @@ func newTestAPI(t *testing.T) (*apiServer, storage.Storage) {
 	cust = customization.Customize{}
 
 	store := memory.New()
-	return newAPI(store, testCollector), store
+	return newAPI(store, testCollector, nil), store
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Luzifer/ots/pkg/customization"
+)
+
+// passwordCacheTTL defines how long a verified password is accepted
+// without checking it against its bcrypt hash again
+const passwordCacheTTL = 5 * time.Minute
+
+type (
+	// passwordCache remembers verified passwords by their HMAC in
+	// order not to run the expensive bcrypt comparison on every request
+	// of a basic-auth user
+	passwordCache struct {
+		entries   map[string]time.Time
+		key       []byte
+		lastSweep time.Time
+		lock      sync.Mutex
+	}
+
+	// principal is the identity a request is made with, anonymous
+	// requests have an empty name
+	principal struct {
+		name   string
+		policy customization.Policy
+	}
+
+	principalContextKey struct{}
+)
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+var verifiedPasswords = &passwordCache{
+	entries: map[string]time.Time{},
+	key:     []byte(rand.Text()),
+}
+
+// authenticate identifies the principal of the request and stores it
+// in the request context. Requests with invalid credentials are
+// rejected, requests without credentials continue as anonymous.
+func (a apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		p, err := identifyPrincipal(r)
+		if err != nil {
+			logrus.WithError(err).WithField("remote", r.RemoteAddr).Debug("rejecting request")
+			a.unauthorized(res, err)
+			return
+		}
+
+		next.ServeHTTP(res, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// checkCreateAllowed responds with an error and returns false in case
+// the principal of the request may not create secrets
+func (a apiServer) checkCreateAllowed(res http.ResponseWriter, r *http.Request) bool {
+	p := principalFromContext(r.Context())
+	switch {
+	case clientCertMissing(r):
+		a.errorResponse(res, http.StatusForbidden, errors.New("client certificate required"), "")
+
+	case !p.policy.DenyCreate:
+		return true
+
+	case p.name == "":
+		a.unauthorized(res, errors.New("authentication required"))
+
+	default:
+		a.errorResponse(res, http.StatusForbidden, errors.New("creating secrets not allowed"), "")
+	}
+
+	return false
+}
+
+func (a apiServer) handleIsWritable(res http.ResponseWriter, r *http.Request) {
+	if !a.checkCreateAllowed(res, r) {
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// unauthorized responds with the challenges for the configured
+// authentication methods
+func (a apiServer) unauthorized(res http.ResponseWriter, err error) {
+	if len(cust.Auth.Users) > 0 {
+		res.Header().Add("WWW-Authenticate", `Basic realm="OTS", charset="UTF-8"`)
+	}
+	if len(cust.Auth.Tokens) > 0 {
+		res.Header().Add("WWW-Authenticate", `Token realm="OTS"`)
+	}
+
+	a.errorResponse(res, http.StatusUnauthorized, err, "")
+}
+
+// anonymousPrincipal returns the principal for requests without
+// credentials
+func anonymousPrincipal() principal {
+	switch {
+	case cust.Auth.Anonymous != nil:
+		return principal{policy: *cust.Auth.Anonymous}
+
+	case authConfigured():
+		return principal{policy: customization.Policy{DenyCreate: true}}
+
+	default:
+		return principal{}
+	}
+}
+
+// authConfigured tells whether any authentication method is configured
+// to identify the principals with
+func authConfigured() bool {
+	return len(cust.Auth.Tokens) > 0 || len(cust.Auth.Users) > 0 || cust.Auth.Proxy.Header != "" || cfg.OIDCIssuer != ""
+}
+
+// identifyPrincipal checks the credentials of the request against the
+// configured authentication methods. Credentials for methods not
+// configured are ignored to not break setups having a reverse proxy
+// doing the authentication.
+func identifyPrincipal(r *http.Request) (principal, error) {
+	scheme, credential, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	switch {
+	case strings.EqualFold(scheme, "token") && len(cust.Auth.Tokens) > 0:
+		for _, t := range cust.Auth.Tokens {
+			if tokenMatchesHash(credential, t.TokenHash) {
+				return principal{name: t.Name, policy: t.Policy}, nil
+			}
+		}
+		return principal{}, errInvalidCredentials
+
+	case strings.EqualFold(scheme, "basic") && len(cust.Auth.Users) > 0:
+		user, pass, _ := r.BasicAuth()
+		for _, u := range cust.Auth.Users {
+			if u.Name != user {
+				continue
+			}
+
+			if !verifiedPasswords.verify(u.PasswordHash, pass) {
+				break
+			}
+			return principal{name: u.Name, policy: u.Policy}, nil
+		}
+		return principal{}, errInvalidCredentials
+	}
+
+	if cfg.OIDCIssuer != "" {
+		// Invalid or expired sessions are treated as anonymous in order
+		// not to lock out recipients having an old cookie
+		if name := sessionUser(r); name != "" {
+			return principal{name: name, policy: policyFor(name, cust.Auth.OIDC.Policy, cust.Auth.OIDC.Policies)}, nil
+		}
+	}
+
+	if proxy := cust.Auth.Proxy; proxy.Header != "" && addrInSubnetList(peerAddr(r), proxy.TrustedSubnets) {
+		if name := r.Header.Get(proxy.Header); name != "" {
+			return principal{name: name, policy: policyFor(name, proxy.Policy, proxy.Policies)}, nil
+		}
+	}
+
+	return anonymousPrincipal(), nil
+}
+
+// verify checks the password against the bcrypt hash, passwords
+// verified within the passwordCacheTTL are accepted from the cache
+func (c *passwordCache) verify(hash, password string) bool {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(hash + "\x00" + password))
+	entry := hex.EncodeToString(mac.Sum(nil))
+
+	c.lock.Lock()
+	expires, ok := c.entries[entry]
+	c.lock.Unlock()
+
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > passwordCacheTTL {
+		for e, exp := range c.entries {
+			if now.After(exp) {
+				delete(c.entries, e)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[entry] = now.Add(passwordCacheTTL)
+
+	return true
+}
+
+// policyFor returns the policy listed for the user or the default
+func policyFor(name string, def customization.Policy, policies map[string]customization.Policy) customization.Policy {
+	if policy, ok := policies[name]; ok {
+		return policy
+	}
+
+	return def
+}
+
+// principalFromContext returns the principal stored by authenticate
+// and falls back to the anonymous principal
+func principalFromContext(ctx context.Context) principal {
+	if p, ok := ctx.Value(principalContextKey{}).(principal); ok {
+		return p
+	}
+
+	return anonymousPrincipal()
+}
+
+// limitExpiry restricts the expiry (in seconds, 0 = no expiry) to the
+// maximum allowed by the policy
+func (p principal) limitExpiry(expiry int64) int64 {
+	if p.policy.MaxExpiry > 0 && (expiry == 0 || expiry > p.policy.MaxExpiry) {
+		return p.policy.MaxExpiry
+	}
+
+	return expiry
+}
+
+// maxSecretSize returns the maximum secret size allowed by the policy
+// and the instance (0 = no limit)
+func (p principal) maxSecretSize() int64 {
+	if p.policy.MaxSecretSize > 0 && (cust.MaxSecretSize <= 0 || p.policy.MaxSecretSize < cust.MaxSecretSize) {
+		return p.policy.MaxSecretSize
+	}
+
+	return cust.MaxSecretSize
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Luzifer/ots/pkg/customization"
+)
+
+func TestAuthentication(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	do := func(method, target, body string, setup func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if setup != nil {
+			setup(req)
+		}
+
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+
+	// Without configuration everybody may create secrets
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/isWritable", "", nil).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/isWritable", "", func(r *http.Request) {
+		r.SetBasicAuth("proxy-user", "handled-elsewhere")
+	}).Code, "credentials for unconfigured methods must be ignored")
+	assert.Equal(t, http.StatusCreated, do(http.MethodGet, "/api/create?secret=foo", "", func(r *http.Request) {
+		r.Header.Del("Content-Type")
+	}).Code, "GET is kept for compatibility without authentication")
+
+	pwHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	cust.Auth = customization.Auth{
+		Proxy: customization.ProxyAuth{
+			Header:         "X-Remote-User",
+			Policies:       map[string]customization.Policy{"mallory": {DenyCreate: true}},
+			TrustedSubnets: []string{"192.0.2.0/24"},
+		},
+		Tokens: []customization.Token{
+			{Name: "ci", TokenHash: hashToken("ci-token"), Policy: customization.Policy{MaxExpiry: 60, MaxSecretSize: 20}},
+		},
+		Users: []customization.User{
+			{Name: "alice", PasswordHash: string(pwHash)},
+			{Name: "bob", PasswordHash: string(pwHash), Policy: customization.Policy{DenyCreate: true}},
+		},
+	}
+
+	for name, tc := range map[string]struct {
+		setup func(*http.Request)
+		code  int
+	}{
+		"anonymous":     {nil, http.StatusUnauthorized},
+		"token":         {func(r *http.Request) { r.Header.Set("Authorization", "Token ci-token") }, http.StatusNoContent},
+		"invalid token": {func(r *http.Request) { r.Header.Set("Authorization", "Token foo") }, http.StatusUnauthorized},
+		"status token":  {func(r *http.Request) { r.Header.Set("Authorization", "Bearer ci-token") }, http.StatusUnauthorized},
+		"user":          {func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, http.StatusNoContent},
+		"wrong pass":    {func(r *http.Request) { r.SetBasicAuth("alice", "foo") }, http.StatusUnauthorized},
+		"denied user":   {func(r *http.Request) { r.SetBasicAuth("bob", "secret") }, http.StatusForbidden},
+		"proxy user":    {func(r *http.Request) { r.Header.Set("X-Remote-User", "carol") }, http.StatusNoContent},
+		"denied proxy":  {func(r *http.Request) { r.Header.Set("X-Remote-User", "mallory") }, http.StatusForbidden},
+		"untrusted proxy": {func(r *http.Request) {
+			r.RemoteAddr = "198.51.100.1:1234"
+			r.Header.Set("X-Remote-User", "carol")
+		}, http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := do(http.MethodGet, "/api/isWritable", "", tc.setup)
+			assert.Equal(t, tc.code, res.Code)
+			if tc.code == http.StatusUnauthorized {
+				assert.Len(t, res.Header().Values("WWW-Authenticate"), 2)
+			}
+
+			res = do(http.MethodPost, "/api/create", `{"secret":"foo"}`, tc.setup)
+			if tc.code == http.StatusNoContent {
+				assert.Equal(t, http.StatusCreated, res.Code)
+			} else {
+				assert.Equal(t, tc.code, res.Code)
+			}
+		})
+	}
+
+	// Cross-site navigations must not create secrets with the
+	// credentials of the user
+	res := do(http.MethodGet, "/api/create?secret=foo", "", func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	assert.Equal(t, http.StatusMethodNotAllowed, res.Code)
+	assert.Equal(t, http.MethodPost, res.Header().Get("Allow"))
+
+	// Reading secrets never requires authentication
+	res = do(http.MethodPost, "/api/create", `{"secret":"foo"}`, func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created apiResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/reveal/"+created.SecretID, "", nil).Code)
+
+	// Policies limit the secrets created by the principal
+	withToken := func(r *http.Request) { r.Header.Set("Authorization", "Token ci-token") }
+
+	res = do(http.MethodPost, "/api/create", `{"secret":"this-is-way-too-long-too"}`, withToken)
+	assert.Equal(t, http.StatusBadRequest, res.Code, "secret size must be limited")
+
+	res = do(http.MethodPost, "/api/create?expire=3600", `{"secret":"foo"}`, withToken)
+	require.Equal(t, http.StatusCreated, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	require.NotNil(t, created.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *created.ExpiresAt, 5*time.Second, "expiry must be limited")
+
+	// Anonymous policy replaces the default denial
+	cust.Auth.Anonymous = &customization.Policy{MaxSecretSize: 15}
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/create", `{"secret":"foo"}`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/create", `{"secret":"0123456789abcdef"}`, nil).Code)
+}
+
+func TestPrincipalLimits(t *testing.T) {
+	_, _ = newTestAPI(t)
+	cust.MaxSecretSize = 100
+
+	assert.Equal(t, int64(3600), principal{}.limitExpiry(3600))
+	assert.Equal(t, int64(60), principal{policy: customization.Policy{MaxExpiry: 60}}.limitExpiry(3600))
+	assert.Equal(t, int64(60), principal{policy: customization.Policy{MaxExpiry: 60}}.limitExpiry(0), "no expiry must be limited")
+	assert.Equal(t, int64(30), principal{policy: customization.Policy{MaxExpiry: 60}}.limitExpiry(30))
+
+	assert.Equal(t, int64(100), principal{}.maxSecretSize())
+	assert.Equal(t, int64(10), principal{policy: customization.Policy{MaxSecretSize: 10}}.maxSecretSize())
+	assert.Equal(t, int64(100), principal{policy: customization.Policy{MaxSecretSize: 1000}}.maxSecretSize(), "instance limit must apply")
+}
+
+func TestPasswordCache(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	c := &passwordCache{entries: map[string]time.Time{}, key: []byte("key")}
+
+	assert.False(t, c.verify(string(hash), "wrong"))
+	assert.Empty(t, c.entries, "failed verifications must not be cached")
+
+	assert.True(t, c.verify(string(hash), "secret"))
+	assert.Len(t, c.entries, 1)
+
+	assert.True(t, c.verify(string(hash), "secret"))
+	assert.Len(t, c.entries, 1, "cached verification must be reused")
+	assert.False(t, c.verify(string(hash), "wrong"))
+
+	// The cached verification is bound to the hash
+	otherHash, err := bcrypt.GenerateFromPassword([]byte("other"), bcrypt.MinCost)
+	require.NoError(t, err)
+	assert.False(t, c.verify(string(otherHash), "secret"))
+
+	// Expired entries are verified again
+	for entry := range c.entries {
+		c.entries[entry] = time.Now().Add(-time.Second)
+	}
+	assert.True(t, c.verify(string(hash), "secret"))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
@@ -26,7 +27,7 @@ type (
 )
 
 var createCmd = &cobra.Command{
-	Use:     "create [-f file]... [--instance url] [--secret-from file]",
+	Use:     "create [-f file]... [--instance url] [--secret-from file] [--output text|json]",
 	Short:   "Create a new encrypted secret in the given OTS instance",
 	Long:    "",
 	Example: `echo "I'm a very secret secret" | ots-cli create`,
@@ -40,11 +41,15 @@ func init() {
 		defaultInstance = inst
 	}
 
+	createCmd.Flags().String("callback-url", "", "URL to send signed webhook events to when the secret is read or expires (must be allowed by the instance)")
 	createCmd.Flags().Duration("expire", 0, "When to expire the secret (0 to use server-default)")
 	createCmd.Flags().StringSliceP("header", "H", nil, "Headers to include in the request (i.e. 'Authorization: Token ...')")
 	createCmd.Flags().String("instance", defaultInstance, "Instance to create the secret with")
 	createCmd.Flags().StringSliceP("file", "f", nil, "File(s) to attach to the secret")
+	createCmd.Flags().Int("max-views", 0, "How often the secret can be read before it is destroyed (0 to read once)")
 	createCmd.Flags().Bool("no-text", false, "Disable secret read (create a secret with only files)")
+	createCmd.Flags().StringP("output", "o", "text", `Output format: "text" yields the URL, "json" also contains the deletion and status tokens`)
+	createCmd.Flags().String("pin", "", "PIN the recipient needs to reveal the secret (transmit it through another channel)")
 	createCmd.Flags().String("secret-from", "-", `File to read the secret content from ("-" for STDIN)`)
 	createCmd.Flags().StringP("user", "u", "", "Username / Password for basic auth, specified as 'user:pass'")
 	rootCmd.AddCommand(createCmd)
@@ -100,24 +105,59 @@ func createRunE(cmd *cobra.Command, _ []string) (err error) {
 		return fmt.Errorf("getting expire flag: %w", err)
 	}
 
+	maxViews, err := cmd.Flags().GetInt("max-views")
+	if err != nil {
+		return fmt.Errorf("getting max-views flag: %w", err)
+	}
+
+	callbackURL, err := cmd.Flags().GetString("callback-url")
+	if err != nil {
+		return fmt.Errorf("getting callback-url flag: %w", err)
+	}
+
+	pin, err := cmd.Flags().GetString("pin")
+	if err != nil {
+		return fmt.Errorf("getting pin flag: %w", err)
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("getting output flag: %w", err)
+	}
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
 	// Execute sanity checks
 	if err = client.SanityCheck(instanceURL, secret); err != nil {
 		return fmt.Errorf("sanity checking secret: %w", err)
 	}
 
 	// Create the secret
-	secretURL, expiresAt, err := client.Create(instanceURL, secret, expire)
+	created, err := client.CreateSecret(instanceURL, secret, expire, client.WithMaxViews(maxViews), client.WithCallbackURL(callbackURL), client.WithPIN(pin))
 	if err != nil {
 		return fmt.Errorf("creating secret: %w", err)
 	}
 
+	if output == "json" {
+		// The whole creation output can be passed to the delete and
+		// status commands
+		if err = json.NewEncoder(os.Stdout).Encode(created); err != nil {
+			return fmt.Errorf("encoding output: %w", err)
+		}
+		return nil
+	}
+
 	// Tell them where to find the secret
-	if expiresAt.IsZero() {
-		logrus.Info("secret created, see URL below")
-	} else {
-		logrus.WithField("expires-at", expiresAt).Info("secret created, see URL below")
+	logger := logrus.WithField("deletion-token", created.DeletionToken)
+	if !created.ExpiresAt.IsZero() {
+		logger = logger.WithField("expires-at", created.ExpiresAt)
+	}
+	if created.StatusToken != "" {
+		logger = logger.WithField("status-token", created.StatusToken)
 	}
-	fmt.Println(secretURL) //nolint:forbidigo // Output intended for STDOUT
+	logger.Info("secret created, see URL below")
+	fmt.Println(created.SecretURL) //nolint:forbidigo // Output intended for STDOUT
 
 	return nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/Luzifer/ots/pkg/client"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete { <url> <deletion-token> | --from file }",
+	Short: "Destroys a secret before it has been read",
+	Long: "Destroys a secret using the deletion token returned on creation. Either pass the URL\n" +
+		"of the secret together with its deletion token or the JSON output of\n" +
+		"`ots-cli create --output json` through --from.",
+	Example: `ots-cli create --output json <secret.txt >created.json && ots-cli delete --from created.json`,
+	Args:    cobra.RangeArgs(0, 2), //nolint:mnd // URL and token
+	RunE:    deleteRunE,
+}
+
+func init() {
+	deleteCmd.Flags().String("from", "", `File to read the creation output from ("-" for STDIN)`)
+	rootCmd.AddCommand(deleteCmd)
+}
+
+func deleteRunE(cmd *cobra.Command, args []string) error {
+	from, err := cmd.Flags().GetString("from")
+	if err != nil {
+		return fmt.Errorf("getting from flag: %w", err)
+	}
+
+	var created client.CreateResult
+
+	switch {
+	case from != "" && len(args) == 0:
+		if created, err = readCreationOutput(from); err != nil {
+			return fmt.Errorf("reading creation output: %w", err)
+		}
+
+	case from == "" && len(args) == 2: //nolint:mnd // URL and token
+		created.SecretURL, created.DeletionToken = args[0], args[1]
+
+	default:
+		return fmt.Errorf("either pass URL and deletion token or the creation output")
+	}
+
+	cmd.SilenceUsage = true
+
+	if created.SecretURL == "" || created.DeletionToken == "" {
+		return fmt.Errorf("URL or deletion token missing")
+	}
+
+	logrus.Info("deleting secret...")
+	if err = client.Delete(created.SecretURL, created.DeletionToken); err != nil {
+		return fmt.Errorf("deleting secret: %w", err)
+	}
+
+	logrus.Info("secret deleted")
+	return nil
+}
+
+func readCreationOutput(from string) (created client.CreateResult, err error) {
+	var src io.Reader = os.Stdin
+	if from != "-" {
+		f, err := os.Open(from) //#nosec:G304 // Opening user specified file is intended
+		if err != nil {
+			return created, fmt.Errorf("opening file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck // The file will be force-closed by program exit
+		src = f
+	}
+
+	if err = json.NewDecoder(src).Decode(&created); err != nil {
+		return created, fmt.Errorf("decoding creation output: %w", err)
+	}
+
+	return created, nil
+}
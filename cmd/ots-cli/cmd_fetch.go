@@ -27,6 +27,7 @@ var fetchCmd = &cobra.Command{
 
 func init() {
 	fetchCmd.Flags().String("file-dir", ".", "Where to put files attached to the secret")
+	fetchCmd.Flags().String("pin", "", "PIN to reveal a PIN protected secret with")
 	rootCmd.AddCommand(fetchCmd)
 }
 
@@ -70,15 +71,20 @@ func fetchRunE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("getting file-dir parameter: %w", err)
 	}
 
+	pin, err := cmd.Flags().GetString("pin")
+	if err != nil {
+		return fmt.Errorf("getting pin parameter: %w", err)
+	}
+
 	// First lets check whether we potentially can write files
 	if err := checkDirWritable(fileDir); err != nil {
 		return fmt.Errorf("checking for directory write: %w", err)
 	}
 
 	logrus.Info("fetching secret...")
-	secret, err := client.Fetch(args[0])
+	secret, err := client.Fetch(args[0], client.WithFetchPIN(pin))
 	if err != nil {
-		return fmt.Errorf("fetching secret")
+		return fmt.Errorf("fetching secret: %w", err)
 	}
 
 	for _, f := range secret.Attachments {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/Luzifer/ots/pkg/client"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status { <url> <status-token> | --from file }",
+	Short: "Checks whether a secret has been read",
+	Long: "Checks the status of a secret using the status token returned on creation without\n" +
+		"revealing it. Either pass the URL of the secret together with its status token or\n" +
+		"the JSON output of `ots-cli create --output json` through --from.\n\n" +
+		"The status is printed to STDOUT: pending, read or expired.",
+	Example: `ots-cli create --output json <secret.txt >created.json && ots-cli status --from created.json`,
+	Args:    cobra.RangeArgs(0, 2), //nolint:mnd // URL and token
+	RunE:    statusRunE,
+}
+
+func init() {
+	statusCmd.Flags().String("from", "", `File to read the creation output from ("-" for STDIN)`)
+	rootCmd.AddCommand(statusCmd)
+}
+
+func statusRunE(cmd *cobra.Command, args []string) error {
+	from, err := cmd.Flags().GetString("from")
+	if err != nil {
+		return fmt.Errorf("getting from flag: %w", err)
+	}
+
+	var created client.CreateResult
+
+	switch {
+	case from != "" && len(args) == 0:
+		if created, err = readCreationOutput(from); err != nil {
+			return fmt.Errorf("reading creation output: %w", err)
+		}
+
+	case from == "" && len(args) == 2: //nolint:mnd // URL and token
+		created.SecretURL, created.StatusToken = args[0], args[1]
+
+	default:
+		return fmt.Errorf("either pass URL and status token or the creation output")
+	}
+
+	cmd.SilenceUsage = true
+
+	if created.SecretURL == "" || created.StatusToken == "" {
+		return fmt.Errorf("URL or status token missing")
+	}
+
+	status, err := client.Status(created.SecretURL, created.StatusToken)
+	if err != nil {
+		return fmt.Errorf("fetching status: %w", err)
+	}
+
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	switch status.State {
+	case client.StatusPending:
+		logger = logger.WithField("remaining-views", status.RemainingViews)
+		if !status.ExpiresAt.IsZero() {
+			logger = logger.WithField("expires-at", status.ExpiresAt)
+		}
+
+	case client.StatusRead:
+		logger = logger.WithField("read-at", status.ReadAt)
+
+	case client.StatusExpired:
+		logger = logger.WithField("expired-at", status.ExpiresAt)
+	}
+
+	logger.Info("secret status, see state below")
+	fmt.Println(status.State) //nolint:forbidigo // Output intended for STDOUT
+
+	return nil
+}
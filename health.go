@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+const (
+	healthStatusOK           = "ok"
+	healthStatusShuttingDown = "shutting_down"
+	healthStatusUnavailable  = "unavailable"
+)
+
+type (
+	healthResponse struct {
+		Status  string         `json:"status"`
+		Version string         `json:"version"`
+		Storage *storageHealth `json:"storage,omitempty"`
+	}
+
+	storageHealth struct {
+		Type      string  `json:"type"`
+		Status    string  `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+	}
+)
+
+// handleLiveness reports the process to be able to serve requests, it
+// does not check the storage as restarting does not fix its backend
+func (a apiServer) handleLiveness(res http.ResponseWriter, _ *http.Request) {
+	a.jsonResponse(res, http.StatusOK, healthResponse{
+		Status:  healthStatusOK,
+		Version: version,
+	})
+}
+
+// handleReadiness reports the instance to be able to handle secrets:
+// It must not be shutting down and its storage must be reachable
+func (a apiServer) handleReadiness(res http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{
+		Status:  healthStatusOK,
+		Version: version,
+		Storage: &storageHealth{
+			Type:   cfg.StorageType,
+			Status: healthStatusOK,
+		},
+	}
+
+	ctx, cancel := a.storageContext(r.Context())
+	defer cancel()
+
+	start := time.Now()
+	err := storage.Ping(ctx, a.store)
+	resp.Storage.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+
+	if err != nil {
+		// The error might contain internal details (i.e. addresses) so
+		// it is only logged
+		logrus.WithError(err).Error("checking storage health")
+		resp.Status = healthStatusUnavailable
+		resp.Storage.Status = healthStatusUnavailable
+	}
+
+	select {
+	case <-a.stopping:
+		resp.Status = healthStatusShuttingDown
+	default:
+	}
+
+	status := http.StatusOK
+	if resp.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	a.jsonResponse(res, status, resp)
+}
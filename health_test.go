@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	api, _ := newTestAPI(t)
+	cfg.StorageType = "mem"
+	cfg.StorageTimeout = 10 * time.Millisecond
+
+	var r *mux.Router
+	register := func() {
+		r = mux.NewRouter()
+		api.Register(r.PathPrefix("/api").Subrouter())
+	}
+	register()
+
+	get := func(path string) (int, healthResponse) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequestWithContext(context.Background(), http.MethodGet, path, nil))
+
+		var resp healthResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+		return res.Code, resp
+	}
+
+	code, resp := get("/api/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatusOK, resp.Status)
+	assert.Equal(t, version, resp.Version)
+	require.NotNil(t, resp.Storage)
+	assert.Equal(t, storageHealth{Type: "mem", Status: healthStatusOK, LatencyMS: resp.Storage.LatencyMS}, *resp.Storage)
+
+	code, _ = get("/api/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	api.store = blockingStore{}
+	register()
+
+	code, resp = get("/api/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusUnavailable, resp.Status)
+	assert.Equal(t, healthStatusUnavailable, resp.Storage.Status)
+
+	code, resp = get("/api/livez")
+	assert.Equal(t, http.StatusOK, code, "liveness must not depend on the storage")
+	assert.Nil(t, resp.Storage)
+
+	api.beginShutdown()
+
+	code, resp = get("/api/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusShuttingDown, resp.Status)
+}
@@ -1,30 +1,67 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/Luzifer/ots/pkg/metrics"
 	"github.com/Luzifer/ots/pkg/storage"
 )
 
+// ipv6PrefixLength is the prefix clients are identified by as they
+// usually get a whole /64 assigned
+const ipv6PrefixLength = 64
+
+// clientIP returns the address identifying the client of the request,
+// IPv6 addresses are reduced to their /64 prefix
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return ip.String()
+	default:
+		return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(ipv6PrefixLength, net.IPv6len*8)), ipv6PrefixLength) //nolint:mnd // Bits per byte
+	}
+}
+
 func requestInSubnetList(r *http.Request, subnets []string) bool {
+	return addrInSubnetList(r.RemoteAddr, subnets)
+}
+
+// addrInSubnetList checks whether the address (with or without port)
+// is contained in one of the subnets
+func addrInSubnetList(addr string, subnets []string) bool {
 	if len(subnets) == 0 {
 		// No subnets specififed: None allowed (without doing the parsing)
 		return false
 	}
 
-	remote, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		logrus.WithError(err).Error("parsing remote address")
-		return false
-	}
-
-	remoteIP := net.ParseIP(remote)
+	remoteIP := parseAddrIP(addr)
 	if remoteIP == nil {
-		logrus.WithError(err).Error("parsing remote address")
+		logrus.WithField("addr", addr).Error("parsing remote address")
 		return false
 	}
 
@@ -43,8 +80,203 @@ func requestInSubnetList(r *http.Request, subnets []string) bool {
 	return false
 }
 
-func updateStoredSecretsCount(store storage.Storage, collector *metrics.Collector) {
-	n, err := store.Count()
+// parseAddrIP parses the IP of an address with or without port, IPv6
+// addresses may be enclosed in brackets
+func parseAddrIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
+
+// callbackURLAllowed checks the callback URL to point to or below one
+// of the allowed URLs: Scheme and host must match, the path must be
+// the same or a sub-path of the allowed one.
+func callbackURLAllowed(raw string, allowed []string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Opaque != "" || u.User != nil {
+		return false
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			// Would allow to escape the allowed path
+			return false
+		}
+	}
+
+	for _, a := range allowed {
+		au, err := url.Parse(a)
+		if err != nil {
+			logrus.WithError(err).WithField("url", a).Warn("invalid webhook url specified")
+			continue
+		}
+
+		if u.Scheme != au.Scheme || !strings.EqualFold(u.Host, au.Host) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(au.Path, "/")
+		if u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateToken creates a random token to be handed out to the client
+// and the hash of it to be stored
+func generateToken() (token, hash string, err error) {
+	buf := make([]byte, tokenLength)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("reading random data: %w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// keyOrRandom returns the configured key or generates a random one.
+// Random keys differ between instances and restarts, therefore a
+// warning is logged when warnRandom is set as the key then needs to be
+// the same on all instances sharing the storage.
+func keyOrRandom(configured, flag string, size int, warnRandom bool) ([]byte, error) {
+	if configured != "" {
+		return []byte(configured), nil
+	}
+
+	if warnRandom {
+		logrus.WithFields(logrus.Fields{
+			"flag":         flag,
+			"storage_type": cfg.StorageType,
+		}).Warn("using random key, set it to the same value on all instances sharing the storage to keep it working across instances and restarts")
+	}
+
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("reading random data: %w", err)
+	}
+
+	return key, nil
+}
+
+// newRevealNonce creates a nonce allowing to reveal the secret with
+// the given ID for the next revealNonceTTL. The nonce is signed instead
+// of being stored so fetching the metadata does not modify the secret.
+func newRevealNonce(id string) string {
+	expiry := strconv.FormatInt(time.Now().Add(revealNonceTTL).Unix(), 10)
+	return expiry + "." + revealNonceSignature(id, expiry)
+}
+
+func revealNonceSignature(id, expiry string) string {
+	mac := hmac.New(sha256.New, revealKey)
+	mac.Write([]byte(id + "." + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validRevealNonce checks the nonce to be issued for the secret with
+// the given ID and not to be expired
+func validRevealNonce(id, nonce string) bool {
+	expiry, signature, ok := strings.Cut(nonce, ".")
+	if !ok {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(revealNonceSignature(id, expiry)))
+}
+
+// hashPIN creates a slow hash of the PIN as PINs are short enough to
+// be brute-forced when using a fast hash
+func hashPIN(pin string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hashing pin: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// pinMatchesHash compares the PIN to the hash created by hashPIN
+func pinMatchesHash(pin, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin)) == nil
+}
+
+// hashToken creates the hash of a token to be stored instead of the
+// token itself. As the tokens have 256bit of entropy there is no need
+// for a slow hash.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// tokenMatchesHash compares the hash of the given token to the stored
+// hash in constant time. An empty hash never matches.
+func tokenMatchesHash(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
+
+// statusErrorCode maps the errors of a status lookup to the HTTP
+// status to respond with
+func statusErrorCode(err error) int {
+	switch {
+	case errors.Is(err, errInvalidToken):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrSecretNotFound):
+		return http.StatusNotFound
+	case isTimeoutError(err):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// statusTokenFromRequest takes the status token from the Authorization
+// header or, as an EventSource is unable to set headers, from the
+// token query parameter
+func statusTokenFromRequest(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// timeRef returns a reference to the given time in UTC to be used in
+// API responses
+func timeRef(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
+
+// isTimeoutError checks whether the storage operation failed because
+// the deadline for it was exceeded
+func isTimeoutError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+func updateStoredSecretsCount(ctx context.Context, store storage.Storage, collector *metrics.Collector) {
+	if cfg.StorageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.StorageTimeout)
+		defer cancel()
+	}
+
+	n, err := store.Count(ctx)
 	if err != nil {
 		logrus.WithError(err).Error("counting stored secrets")
 		return
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallbackURLAllowed(t *testing.T) {
+	allowed := []string{
+		"https://hooks.example.com/ots/",
+		"http://internal.example.com:8080",
+	}
+
+	for raw, want := range map[string]bool{
+		"https://hooks.example.com/ots":              true,
+		"https://hooks.example.com/ots/":             true,
+		"https://HOOKS.example.com/ots/secret?x=1":   true,
+		"http://internal.example.com:8080/any/path":  true,
+		"http://hooks.example.com/ots/":              false, // Scheme mismatch
+		"https://hooks.example.com/otsfoo":           false, // Not a sub-path
+		"https://hooks.example.com/ots/../admin":     false, // Escapes the path
+		"https://hooks.example.com/ots/%2e%2e/admin": false,
+		"https://user@hooks.example.com/ots/":        false,
+		"https://hooks.example.com.evil.com/ots/":    false,
+		"http://internal.example.com/any/path":       false, // Port mismatch
+		"/ots/":                                      false,
+		"ftp://hooks.example.com/ots/":               false,
+	} {
+		assert.Equal(t, want, callbackURLAllowed(raw, allowed), raw)
+	}
+
+	assert.False(t, callbackURLAllowed("https://hooks.example.com/ots/", nil), "nothing allowed without allowlist")
+}
+
+func TestClientIP(t *testing.T) {
+	for remote, want := range map[string]string{
+		"192.0.2.1:1234":              "192.0.2.1",
+		"[2001:db8:1:2:3:4:5:6]:1234": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:ffff::1]:1234": "2001:db8:1:2::/64",
+		"[::ffff:192.0.2.1]:1234":     "192.0.2.1",
+		"@":                           "@", // Unix socket
+		"not-an-address":              "not-an-address",
+	} {
+		r := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		assert.Equal(t, want, clientIP(r), remote)
+	}
+}
+
+func TestKeyOrRandom(t *testing.T) {
+	hook := logtest.NewGlobal()
+	t.Cleanup(hook.Reset)
+
+	key, err := keyOrRandom("configured", "reveal-secret", 32, true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("configured"), key)
+	assert.Empty(t, hook.AllEntries(), "configured key must not warn")
+
+	key, err = keyOrRandom("", "reveal-secret", 32, false)
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+	assert.Empty(t, hook.AllEntries(), "random key must not warn for unshared storages")
+
+	other, err := keyOrRandom("", "reveal-secret", 32, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "reveal-secret", hook.LastEntry().Data["flag"])
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// proxyHeaderTimeout limits how long a connection from a proxy may
+	// take to send its PROXY protocol header
+	proxyHeaderTimeout = 5 * time.Second
+
+	// systemdFirstFD is the first file descriptor passed by systemd
+	// socket activation (SD_LISTEN_FDS_START)
+	systemdFirstFD = 3
+
+	unixPrefix = "unix:"
+)
+
+// unixListener removes the socket on close as it was moved into place
+// after creating it
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+// listen opens the listener for the server: The socket passed by
+// systemd when socket activated, a unix socket when the listen address
+// is given as unix:/path or a TCP socket otherwise. TCP sockets accept
+// PROXY protocol headers from the configured subnets.
+func listen() (net.Listener, error) {
+	l, err := systemdListener()
+	switch {
+	case err != nil:
+		return nil, err
+
+	case l != nil:
+		logrus.Info("using socket passed by systemd, ignoring listen address")
+
+	default:
+		if l, err = listenAddr(cfg.Listen); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cust.ProxyProtocolSubnets) == 0 {
+		return l, nil
+	}
+
+	if l.Addr().Network() != "tcp" {
+		logrus.Warn("PROXY protocol is only supported on TCP sockets, ignoring proxy protocol subnets")
+		return l, nil
+	}
+
+	// Proxies must send the header while everyone else (i.e. health
+	// checks) may connect directly but cannot fake their address
+	policy, err := proxyproto.PolicyFromRanges(cust.ProxyProtocolSubnets, proxyproto.REQUIRE, proxyproto.IGNORE)
+	if err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("parsing proxy protocol subnets: %w", err)
+	}
+
+	return &proxyproto.Listener{
+		Listener:          l,
+		ConnPolicy:        policy,
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}, nil
+}
+
+// listenAddr opens a unix socket when the address is given as
+// unix:/path or a TCP socket otherwise
+func listenAddr(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return listenUnix(strings.TrimPrefix(addr, unixPrefix))
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %q: %w", addr, err)
+	}
+
+	return l, nil
+}
+
+// listenUnix creates the unix socket at the path with the configured
+// permissions, a socket left behind by a crashed instance is replaced.
+// The socket is created in a private directory and moved into place
+// after setting its permissions so it is never accessible with wider
+// permissions.
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(cfg.ListenSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("parsing listen-socket-mode: %w", err)
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ots-")
+	if err != nil {
+		return nil, fmt.Errorf("creating socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck // Directory is empty after moving the socket
+
+	tmpPath := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %q: %w", path, err)
+	}
+
+	if err = os.Chmod(tmpPath, fs.FileMode(mode)); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("setting socket permissions: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("moving socket into place: %w", err)
+	}
+
+	return unixListener{Listener: l, path: path}, nil
+}
+
+// Close closes the listener and removes the socket which is not done
+// by the listener itself as it was moved after creating it
+func (u unixListener) Close() error {
+	err := u.Listener.Close()
+	if rmErr := os.Remove(u.path); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+		err = errors.Join(err, fmt.Errorf("removing socket: %w", rmErr))
+	}
+
+	return err //nolint:wrapcheck // Listener errors are passed through
+}
+
+// systemdListener returns the first socket passed through systemd
+// socket activation or nil when the process was not socket activated
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	if fds > 1 {
+		logrus.WithField("fds", fds).Warn("multiple sockets passed by systemd, using the first one")
+	}
+
+	// Sockets must not be passed on to child processes
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(env)
+	}
+
+	f := os.NewFile(uintptr(systemdFirstFD), "systemd-socket")
+	defer f.Close() //nolint:errcheck // The listener uses a duplicate
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("using socket passed by systemd: %w", err)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/customization"
+)
+
+func TestListenProxyProtocol(t *testing.T) {
+	oldCust, oldListen := cust, cfg.Listen
+	t.Cleanup(func() { cust, cfg.Listen = oldCust, oldListen })
+
+	cfg.Listen = "127.0.0.1:0"
+	cust = customization.Customize{ProxyProtocolSubnets: []string{"127.0.0.0/8"}}
+
+	l, err := listen()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint:errcheck // Test connection
+
+		_, _ = conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 1234 80\r\nGET / HTTP/1.0\r\n\r\n"))
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	buf := make([]byte, 3)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(buf), "header must be consumed")
+	assert.Equal(t, "192.0.2.1:1234", conn.RemoteAddr().String())
+
+	cust.ProxyProtocolSubnets = []string{"foo"}
+	_, err = listen()
+	assert.Error(t, err)
+}
+
+func TestListenUnix(t *testing.T) {
+	oldCust, oldListen, oldMode := cust, cfg.Listen, cfg.ListenSocketMode
+	t.Cleanup(func() { cust, cfg.Listen, cfg.ListenSocketMode = oldCust, oldListen, oldMode })
+
+	sock := filepath.Join(t.TempDir(), "ots.sock")
+	cfg.Listen = "unix:" + sock
+	cfg.ListenSocketMode = "0600"
+	cust = customization.Customize{
+		ProxyProtocolSubnets: []string{"127.0.0.0/8"},
+		TrustedProxyHeader:   "X-Forwarded-For",
+	}
+
+	l, err := listen()
+	require.NoError(t, err)
+
+	info, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(sock))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary socket directory must be removed")
+
+	srv := &http.Server{
+		Handler: handleTrustedProxies(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(res, r.RemoteAddr)
+		})),
+		ReadHeaderTimeout: time.Second,
+	}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+
+	req, err := http.NewRequest(http.MethodGet, "http://ots/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck // Test response
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:0", string(body), "unix socket peer must be trusted")
+
+	// Socket left behind by a crashed instance is replaced
+	require.NoError(t, srv.Close())
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	l, err = listen()
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	_, err = os.Lstat(sock)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "socket must be removed on close")
+
+	cfg.ListenSocketMode = "foo"
+	_, err = listen()
+	assert.Error(t, err)
+}
+
+func TestSystemdListener(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	l, err := systemdListener()
+	assert.NoError(t, err)
+	assert.Nil(t, l, "sockets for other processes must be ignored")
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+
+	_, err = systemdListener()
+	assert.Error(t, err)
+}
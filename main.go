@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"embed"
 	"encoding/base64"
@@ -9,7 +10,9 @@ import (
 	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -21,27 +24,58 @@ import (
 
 	"github.com/Luzifer/ots/pkg/customization"
 	"github.com/Luzifer/ots/pkg/metrics"
+	"github.com/Luzifer/ots/pkg/webhook"
 )
 
-const scriptNonceSize = 32
+const (
+	revealKeySize   = 32
+	scriptNonceSize = 32
+)
 
 var (
 	cfg struct {
-		Customize      string `flag:"customize" default:"" description:"Customize-File to load"`
-		Listen         string `flag:"listen" default:":3000" description:"IP/Port to listen on"`
-		LogRequests    bool   `flag:"log-requests" default:"true" description:"Enable request logging"`
-		LogLevel       string `flag:"log-level" default:"info" description:"Set log level (debug, info, warning, error)"`
-		SecretExpiry   int64  `flag:"secret-expiry" default:"0" description:"Maximum expiry of the stored secrets in seconds"`
-		StorageType    string `flag:"storage-type" default:"mem" description:"Storage to use for putting secrets to" validate:"nonzero"` //revive:disable-line:struct-tag // Matches wrong validation library
-		VersionAndExit bool   `flag:"version" default:"false" description:"Print version information and exit"`
-		EnableTLS      bool   `flag:"enable-tls" default:"false" description:"Enable HTTPS/TLS"`
-		CertFile       string `flag:"cert-file" default:"" description:"Path to the TLS certificate file"`
-		KeyFile        string `flag:"key-file" default:"" description:"Path to the TLS private key file"`
-	}
-
-	assets   filehelpers.FSStack
-	cust     customization.Customize
-	indexTpl *template.Template
+		AdminListen           string        `flag:"admin-listen" default:"" description:"IP/Port (or unix:/path/to/socket) to serve metrics, pprof and health checks on instead of the main listener"`
+		BruteForceBanAfter    int64         `flag:"brute-force-ban-after" default:"0" description:"Reads of unknown secrets within the window after which the client is banned (0 to disable)"`
+		BruteForceBanDuration time.Duration `flag:"brute-force-ban-duration" default:"1h" description:"How long clients are banned from reading secrets"`
+		BruteForceDelayAfter  int64         `flag:"brute-force-delay-after" default:"0" description:"Reads of unknown secrets within the window after which reads of the client are delayed (0 to disable)"`
+		BruteForceWindow      time.Duration `flag:"brute-force-window" default:"15m" description:"Window in which reads of unknown secrets are counted"`
+		Customize             string        `flag:"customize" default:"" description:"Customize-File to load"`
+		Listen                string        `flag:"listen" default:":3000" description:"IP/Port to listen on (or unix:/path/to/socket)"`
+		ListenSocketMode      string        `flag:"listen-socket-mode" default:"0660" description:"Permissions of the unix socket to listen on"`
+		LogRequests           bool          `flag:"log-requests" default:"true" description:"Enable request logging"`
+		LogLevel              string        `flag:"log-level" default:"info" description:"Set log level (debug, info, warning, error)"`
+		OIDCClientID          string        `flag:"oidc-client-id" default:"" description:"Client-ID registered with the OpenID Connect provider"`
+		OIDCClientSecret      string        `flag:"oidc-client-secret" default:"" description:"Client-Secret registered with the OpenID Connect provider"`
+		OIDCIssuer            string        `flag:"oidc-issuer" default:"" description:"Issuer URL of the OpenID Connect provider to log in users with (login is disabled if unset)"`
+		OIDCRedirectURL       string        `flag:"oidc-redirect-url" default:"" description:"Public URL of the login callback (i.e. https://ots.example.com/auth/callback)"`
+		OIDCSessionTTL        time.Duration `flag:"oidc-session-ttl" default:"12h" description:"How long users stay logged in"`
+		PINMaxAttempts        int           `flag:"pin-max-attempts" default:"3" description:"Number of wrong PINs after which a PIN protected secret is destroyed"`
+		RateLimitCreate       string        `flag:"rate-limit-create" default:"" description:"Secrets each client may create as <count>/<interval> (i.e. 10/1m, unlimited if unset)"`
+		RateLimitRead         string        `flag:"rate-limit-read" default:"" description:"Secrets each client may read as <count>/<interval> (i.e. 30/1m, unlimited if unset)"`
+		RateLimitStorage      string        `flag:"rate-limit-storage" default:"" description:"Where to keep the rate limit and brute-force state (mem, redis to share it between instances, defaults to redis when using redis storage, required when using a storage shared between instances)"`
+		RevealSecret          string        `flag:"reveal-secret" default:"" description:"Secret to sign reveal nonces with (random if unset, must be the same on all instances)"`
+		SecretExpiry          int64         `flag:"secret-expiry" default:"0" description:"Maximum expiry of the stored secrets in seconds"`
+		SessionSecret         string        `flag:"session-secret" default:"" description:"Secret to sign login sessions with (random if unset, must be the same on all instances)"`
+		ShutdownDelay         time.Duration `flag:"shutdown-delay" default:"0s" description:"How long to report unhealthy before draining the requests on shutdown (to let load balancers stop sending requests)"`
+		ShutdownTimeout       time.Duration `flag:"shutdown-timeout" default:"30s" description:"Maximum duration to wait for in-flight requests on shutdown"`
+		StatusRetention       time.Duration `flag:"status-retention" default:"24h" description:"How long to keep the read-status of a secret after it was read or expired (0 to disable status tracking)"`
+		StorageTimeout        time.Duration `flag:"storage-timeout" default:"5s" description:"Maximum duration of a single storage operation (0 to disable)"`
+		StorageType           string        `flag:"storage-type" default:"mem" description:"Storage to use for putting secrets to" validate:"nonzero"` //revive:disable-line:struct-tag // Matches wrong validation library
+		VersionAndExit        bool          `flag:"version" default:"false" description:"Print version information and exit"`
+		WebhookSecret         string        `flag:"webhook-secret" default:"" description:"Secret to sign webhook events with (webhooks are disabled if unset)"`
+		EnableTLS             bool          `flag:"enable-tls" default:"false" description:"Enable HTTPS/TLS"`
+		CertFile              string        `flag:"cert-file" default:"" description:"Path to the TLS certificate file"`
+		KeyFile               string        `flag:"key-file" default:"" description:"Path to the TLS private key file"`
+		ClientCAFile          string        `flag:"client-ca-file" default:"" description:"Path to the CA certificates to verify client certificates with"`
+		ClientCertMode        string        `flag:"client-cert-mode" default:"none" description:"Which requests need a client certificate (none, create, all)"`
+		TLSCipherSuites       []string      `flag:"tls-cipher-suites" default:"" description:"Cipher suites to allow for TLS 1.2 and below (Go defaults if unset)"`
+		TLSMinVersion         string        `flag:"tls-min-version" default:"1.2" description:"Minimum TLS version to accept (1.0, 1.1, 1.2, 1.3)"`
+	}
+
+	assets    filehelpers.FSStack
+	cust      customization.Customize
+	indexTpl  *template.Template
+	revealKey []byte
 
 	version = "dev"
 )
@@ -76,6 +110,18 @@ func initApp() error {
 	}
 	logrus.SetLevel(l)
 
+	// Secrets stored in a shared storage might be revealed through
+	// another instance than the one handing out the nonce
+	if revealKey, err = keyOrRandom(cfg.RevealSecret, "reveal-secret", revealKeySize, sharedStorageTypes[cfg.StorageType]); err != nil {
+		return fmt.Errorf("generating reveal key: %w", err)
+	}
+
+	// Sessions are only used by the login, when signed with a random
+	// key they are not accepted by other instances or after a restart
+	if sessionKey, err = keyOrRandom(cfg.SessionSecret, "session-secret", sessionKeySize, sharedStorageTypes[cfg.StorageType] && cfg.OIDCIssuer != ""); err != nil {
+		return fmt.Errorf("generating session key: %w", err)
+	}
+
 	if cust, err = customization.Load(cfg.Customize); err != nil {
 		return fmt.Errorf("loading customizations: %w", err)
 	}
@@ -119,18 +165,43 @@ func main() {
 	if err != nil {
 		logrus.WithError(err).Fatal("initializing storage")
 	}
-	api := newAPI(store, collector)
+
+	// Initialize webhook delivery
+	var webhooks *webhook.Dispatcher
+	if cfg.WebhookSecret != "" {
+		webhooks = webhook.New(cfg.WebhookSecret)
+	}
+
+	api := newAPI(store, collector, webhooks)
+	limits, err := newLimitBackend()
+	if err != nil {
+		logrus.WithError(err).Fatal("initializing rate limit storage")
+	}
+	if api.createLimiter, api.readLimiter, err = newRateLimiters(limits); err != nil {
+		logrus.WithError(err).Fatal("initializing rate limits")
+	}
+	api.readGuard = newReadGuard(limits)
 
 	// Initialize server
 	r := mux.NewRouter()
 
 	api.Register(r.PathPrefix("/api").Subrouter())
 
-	r.Handle("/metrics", handleRemoveAcceptEncoding(metrics.Handler())).
-		Methods(http.MethodGet).
-		MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
-			return requestInSubnetList(r, cust.MetricsAllowedSubnets)
-		})
+	if cfg.OIDCIssuer != "" {
+		login, err := newOIDCLogin(context.Background())
+		if err != nil {
+			logrus.WithError(err).Fatal("initializing OpenID Connect login")
+		}
+		login.Register(r.PathPrefix("/auth").Subrouter())
+	}
+
+	if cfg.AdminListen == "" {
+		r.Handle("/metrics", handleRemoveAcceptEncoding(metrics.Handler())).
+			Methods(http.MethodGet).
+			MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+				return requestInSubnetList(r, cust.MetricsAllowedSubnets)
+			})
+	}
 
 	r.HandleFunc("/", handleIndex).
 		Methods(http.MethodGet)
@@ -140,8 +211,14 @@ func main() {
 	var hdl http.Handler = r
 	hdl = httphelpers.GzipHandler(hdl)
 	if cfg.LogRequests {
-		hdl = httphelpers.NewHTTPLogHandlerWithLogger(hdl, logrus.StandardLogger())
+		// The client address is resolved by handleTrustedProxies, the
+		// headers must not be trusted blindly
+		logHdl := httphelpers.NewHTTPLogHandlerWithLogger(hdl, logrus.StandardLogger()).(httphelpers.HTTPLogHandler)
+		logHdl.TrustedIPHeaders = nil
+		hdl = logHdl
 	}
+	hdl = handleEventStreams(r, hdl)
+	hdl = handleTrustedProxies(hdl)
 
 	server := &http.Server{
 		Addr:              cfg.Listen,
@@ -149,12 +226,24 @@ func main() {
 		ReadHeaderTimeout: time.Second,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Start periodic stored metrics update (required for multi-instance
 	// OTS hosting as other instances will create / delete secrets and
 	// we need to keep up with that)
 	go func() {
-		for t := time.NewTicker(time.Minute); ; <-t.C {
-			updateStoredSecretsCount(store, collector)
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+
+		for {
+			updateStoredSecretsCount(ctx, store, collector)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
 		}
 	}()
 
@@ -165,19 +254,50 @@ func main() {
 	}).Info("ots started")
 
 	if cfg.EnableTLS {
-		if cfg.CertFile == "" || cfg.KeyFile == "" {
-			logrus.Fatal("TLS is enabled but cert-file or key-file is not provided")
+		if server.TLSConfig, err = newTLSConfig(ctx); err != nil {
+			logrus.WithError(err).Fatal("initializing TLS")
 		}
-		logrus.Infof("Starting HTTPS server on %s", cfg.Listen)
-		if err := server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != nil {
-			logrus.WithError(err).Fatal("HTTPS server quit unexpectedly")
+	}
+
+	listener, err := listen()
+	if err != nil {
+		logrus.WithError(err).Fatal("opening listener")
+	}
+
+	serveErr := make(chan error, 2)
+	go func() { serveErr <- serve(server, listener) }()
+
+	var admin *http.Server
+	if cfg.AdminListen != "" {
+		adminListener, err := listenAddr(cfg.AdminListen)
+		if err != nil {
+			logrus.WithError(err).Fatal("opening admin listener")
 		}
-	} else {
-		logrus.Infof("Starting HTTP server on %s", cfg.Listen)
-		if err := server.ListenAndServe(); err != nil {
-			logrus.WithError(err).Fatal("HTTP server quit unexpectedly")
+
+		admin = &http.Server{
+			Handler:           newAdminRouter(api),
+			ReadHeaderTimeout: time.Second,
 		}
+		go func() { serveErr <- serve(admin, adminListener) }()
 	}
+
+	select {
+	case err = <-serveErr:
+		logrus.WithError(err).Fatal("server quit unexpectedly")
+	case <-ctx.Done():
+	}
+
+	// Restore the default signal handling so a second signal kills the
+	// process instead of waiting for the shutdown
+	stop()
+	logrus.Info("shutting down")
+
+	if err = shutdown(server, admin, api, store, limits); err != nil {
+		logrus.WithError(err).Error("shutting down")
+		os.Exit(1)
+	}
+
+	logrus.Info("ots stopped")
 }
 
 func assetDelivery(w http.ResponseWriter, r *http.Request) {
@@ -204,7 +324,7 @@ func assetDelivery(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleIndex(w http.ResponseWriter, _ *http.Request) {
+func handleIndex(w http.ResponseWriter, r *http.Request) {
 	inlineContentNonce := make([]byte, scriptNonceSize)
 	if _, err := rand.Read(inlineContentNonce); err != nil {
 		logrus.WithError(err).Error("generating script nonce")
@@ -228,12 +348,16 @@ func handleIndex(w http.ResponseWriter, _ *http.Request) {
 	if err := indexTpl.Execute(w, struct {
 		Customize          customization.Customize
 		InlineContentNonce string
+		LoginEnabled       bool
 		MaxSecretExpiry    int64
+		User               string
 		Version            string
 	}{
 		Customize:          cust,
 		InlineContentNonce: inlineContentNonceStr,
+		LoginEnabled:       cfg.OIDCIssuer != "",
 		MaxSecretExpiry:    cfg.SecretExpiry,
+		User:               indexUser(r),
 		Version:            version,
 	}); err != nil {
 		http.Error(w, fmt.Errorf("executing template: %w", err).Error(), http.StatusInternalServerError)
@@ -241,6 +365,34 @@ func handleIndex(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// indexUser returns the name of the user logged in through OpenID
+// Connect the index is rendered for. Other credentials are not checked
+// here as they are verified by the API and the interface only shows
+// the login state.
+func indexUser(r *http.Request) string {
+	if cfg.OIDCIssuer == "" {
+		return ""
+	}
+
+	return sessionUser(r)
+}
+
+// handleEventStreams passes requests for the status event stream
+// directly to the router: The compression and logging handlers wrap the
+// ResponseWriter without being able to flush it which is required to
+// deliver the events as they happen. The stream is identified by its
+// route as the headers are under control of the client.
+func handleEventStreams(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil && match.Route.GetName() == routeStatusEvents {
+			router.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func handleRemoveAcceptEncoding(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.Header.Del("Accept-Encoding")
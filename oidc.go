@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcFlowCookie = "ots-oidc-flow"
+	oidcFlowTTL    = 10 * time.Minute
+	sessionCookie  = "ots-session"
+	sessionKeySize = 32
+)
+
+type (
+	// oidcLogin implements the authorization-code flow against the
+	// configured provider and creates a session cookie afterwards
+	oidcLogin struct {
+		config   oauth2.Config
+		verifier *oidc.IDTokenVerifier
+	}
+
+	// oidcFlow is stored in a cookie between redirecting the user to
+	// the provider and receiving the callback
+	oidcFlow struct {
+		Expires  int64  `json:"exp"`
+		Nonce    string `json:"nonce"`
+		State    string `json:"state"`
+		Verifier string `json:"verifier"`
+	}
+
+	// session is stored signed in the session cookie, nothing about
+	// the session is kept on the server
+	session struct {
+		Expires int64  `json:"exp"`
+		User    string `json:"user"`
+	}
+)
+
+var (
+	errInvalidSignature = errors.New("invalid signature")
+
+	sessionKey []byte
+)
+
+// newOIDCLogin discovers the configuration of the provider
+func newOIDCLogin(ctx context.Context) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering provider: %w", err)
+	}
+
+	return &oidcLogin{
+		config: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+	}, nil
+}
+
+// Register attaches the login handlers to the given router
+func (o *oidcLogin) Register(r *mux.Router) {
+	r.HandleFunc("/callback", o.handleCallback).Methods(http.MethodGet)
+	r.HandleFunc("/login", o.handleLogin).Methods(http.MethodGet)
+	r.HandleFunc("/logout", o.handleLogout).Methods(http.MethodPost)
+}
+
+func (o *oidcLogin) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var flow oidcFlow
+	c, err := r.Cookie(oidcFlowCookie)
+	if err == nil {
+		err = decodeSigned(c.Value, &flow)
+	}
+	setCookie(w, oidcFlowCookie, "", -1)
+
+	if err != nil || flow.Expires < time.Now().Unix() ||
+		subtle.ConstantTimeCompare([]byte(flow.State), []byte(r.FormValue("state"))) != 1 {
+		http.Error(w, "invalid or expired login attempt", http.StatusBadRequest)
+		return
+	}
+
+	if e := r.FormValue("error"); e != "" {
+		logrus.WithField("error", e).WithField("description", r.FormValue("error_description")).Warn("login denied by provider")
+		http.Error(w, "login denied by provider", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := o.exchange(r.Context(), r.FormValue("code"), flow)
+	if err != nil {
+		logrus.WithError(err).Error("completing login")
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	value, err := encodeSigned(session{Expires: time.Now().Add(cfg.OIDCSessionTTL).Unix(), User: user})
+	if err != nil {
+		logrus.WithError(err).Error("encoding session")
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, sessionCookie, value, cfg.OIDCSessionTTL)
+
+	logrus.WithField("user", user).Info("user logged in")
+
+	// Relative to keep working when the instance is served below a path
+	w.Header().Set("Location", "../")
+	w.WriteHeader(http.StatusFound)
+}
+
+func (*oidcLogin) handleLogout(w http.ResponseWriter, _ *http.Request) {
+	setCookie(w, sessionCookie, "", -1)
+
+	w.Header().Set("Location", "../")
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func (o *oidcLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	flow := oidcFlow{
+		Expires:  time.Now().Add(oidcFlowTTL).Unix(),
+		Nonce:    rand.Text(),
+		State:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+	}
+
+	value, err := encodeSigned(flow)
+	if err != nil {
+		logrus.WithError(err).Error("encoding login flow")
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, oidcFlowCookie, value, oidcFlowTTL)
+
+	http.Redirect(w, r, o.config.AuthCodeURL(
+		flow.State,
+		oidc.Nonce(flow.Nonce),
+		oauth2.S256ChallengeOption(flow.Verifier),
+	), http.StatusFound)
+}
+
+// exchange redeems the code and returns the user from the verified ID
+// token
+func (o *oidcLogin) exchange(ctx context.Context, code string, flow oidcFlow) (string, error) {
+	token, err := o.config.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return "", fmt.Errorf("exchanging code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("token response contains no id_token")
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", fmt.Errorf("verifying id_token: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		return "", errors.New("id_token nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err = idToken.Claims(&claims); err != nil {
+		return "", fmt.Errorf("decoding claims: %w", err)
+	}
+
+	user, _ := claims[cust.Auth.OIDC.UsernameClaim].(string)
+	if user == "" {
+		return "", fmt.Errorf("id_token contains no %q claim", cust.Auth.OIDC.UsernameClaim)
+	}
+
+	return user, nil
+}
+
+// decodeSigned checks the signature of a value created by encodeSigned
+// and decodes it into v
+func decodeSigned(value string, v any) error {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signPayload(payload))) {
+		return errInvalidSignature
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("decoding payload: %w", err)
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshalling payload: %w", err)
+	}
+
+	return nil
+}
+
+// encodeSigned serializes v into a signed value to be stored on the
+// client side
+func encodeSigned(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshalling payload: %w", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signPayload(payload), nil
+}
+
+// sessionUser returns the user of a valid session cookie sent with the
+// request or an empty string
+func sessionUser(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+
+	var s session
+	if err = decodeSigned(c.Value, &s); err != nil || s.Expires < time.Now().Unix() {
+		return ""
+	}
+
+	return s.User
+}
+
+// setCookie sets a cookie valid for the given duration, negative
+// durations remove the cookie
+func setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		MaxAge:   maxAge,
+		Name:     name,
+		Path:     "/",
+		// Lax keeps the cookie from being sent with cross-site POST
+		// requests to the API while allowing the provider to redirect
+		// back to the callback
+		SameSite: http.SameSiteLaxMode,
+		Secure:   strings.HasPrefix(cfg.OIDCRedirectURL, "https://"),
+		Value:    value,
+	})
+}
+
+func signPayload(payload string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
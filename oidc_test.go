@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/customization"
+)
+
+// testIdP is a minimal OpenID Connect provider issuing ID tokens for
+// a fixed set of claims to every authorization request
+type testIdP struct {
+	*httptest.Server
+
+	claims map[string]any
+	key    *rsa.PrivateKey
+
+	lock  sync.Mutex
+	codes map[string]url.Values
+}
+
+func newTestIdP(t *testing.T, claims map[string]any) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:mnd // Test key
+	require.NoError(t, err)
+
+	idp := &testIdP{claims: claims, codes: map[string]url.Values{}, key: key}
+
+	r := http.NewServeMux()
+	r.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"issuer":                                idp.URL,
+			"jwks_uri":                              idp.URL + "/jwks",
+			"token_endpoint":                        idp.URL + "/token",
+		}))
+	})
+	r.HandleFunc("GET /jwks", func(w http.ResponseWriter, _ *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Algorithm: "RS256", Key: &key.PublicKey, KeyID: "test", Use: "sig"},
+		}}))
+	})
+	r.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := rand.Text()
+		idp.lock.Lock()
+		idp.codes[code] = r.URL.Query()
+		idp.lock.Unlock()
+
+		target, _ := url.Parse(r.FormValue("redirect_uri"))
+		target.RawQuery = url.Values{"code": {code}, "state": {r.FormValue("state")}}.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	})
+	r.HandleFunc("POST /token", idp.handleToken(t))
+
+	idp.Server = httptest.NewServer(r)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+func (i *testIdP) handleToken(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i.lock.Lock()
+		auth, ok := i.codes[r.FormValue("code")]
+		delete(i.codes, r.FormValue("code"))
+		i.lock.Unlock()
+
+		if !ok || !assert.NotEmpty(t, r.FormValue("code_verifier"), "PKCE must be used") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		claims := map[string]any{
+			"aud":   auth.Get("client_id"),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"iss":   i.URL,
+			"nonce": auth.Get("nonce"),
+			"sub":   "1234",
+		}
+		for k, v := range i.claims {
+			claims[k] = v
+		}
+
+		payload, err := json.Marshal(claims)
+		require.NoError(t, err)
+
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.RS256, Key: i.key},
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"),
+		)
+		require.NoError(t, err)
+
+		jws, err := signer.Sign(payload)
+		require.NoError(t, err)
+		idToken, err := jws.CompactSerialize()
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "foo",
+			"id_token":     idToken,
+			"token_type":   "Bearer",
+		}))
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	api, _ := newTestAPI(t)
+	idp := newTestIdP(t, map[string]any{"email": "alice@example.com"})
+
+	oldIndexTpl, oldSessionKey := indexTpl, sessionKey
+	t.Cleanup(func() { indexTpl, sessionKey = oldIndexTpl, oldSessionKey })
+
+	sessionKey = []byte("session-key")
+	cust.Auth.OIDC = customization.OIDCAuth{
+		Policies:      map[string]customization.Policy{"mallory@example.com": {DenyCreate: true}},
+		UsernameClaim: "email",
+	}
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+	r.HandleFunc("/", handleIndex)
+	indexTpl = template.Must(template.New("index.html").Parse(`user={{ .User }} login={{ .LoginEnabled }}`))
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	cfg.OIDCClientID = "ots"
+	cfg.OIDCIssuer = idp.URL
+	cfg.OIDCRedirectURL = srv.URL + "/auth/callback"
+	cfg.OIDCSessionTTL = time.Hour
+
+	login, err := newOIDCLogin(context.Background())
+	require.NoError(t, err)
+	login.Register(r.PathPrefix("/auth").Subrouter())
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+
+	get := func(target string) (int, string) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+target, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck // Test
+
+		body := new(strings.Builder)
+		_, err = io.Copy(body, resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, body.String()
+	}
+
+	// Anonymous users are not allowed to create secrets
+	code, _ := get("/api/isWritable")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, body := get("/")
+	assert.Equal(t, "user= login=true", body)
+
+	// Login redirects through the provider back to the index
+	code, body = get("/auth/login")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "user=alice@example.com login=true", body)
+
+	code, _ = get("/api/isWritable")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	// A forged callback without the flow cookie is rejected
+	code, _ = get("/auth/callback?code=foo&state=bar")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Tampered sessions are anonymous
+	u, _ := url.Parse(srv.URL)
+	cookies := jar.Cookies(u)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "alice@example.com", sessionUser(requestWithCookie(sessionCookie, cookies[0].Value)))
+
+	forged, err := json.Marshal(session{Expires: time.Now().Add(time.Hour).Unix(), User: "mallory@example.com"})
+	require.NoError(t, err)
+	_, signature, _ := strings.Cut(cookies[0].Value, ".")
+	assert.Equal(t, "", sessionUser(requestWithCookie(sessionCookie, base64.RawURLEncoding.EncodeToString(forged)+"."+signature)))
+
+	expired, err := encodeSigned(session{Expires: time.Now().Add(-time.Minute).Unix(), User: "alice@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "", sessionUser(requestWithCookie(sessionCookie, expired)))
+
+	// Denied users are logged in but may not create secrets
+	denied, err := encodeSigned(session{Expires: time.Now().Add(time.Hour).Unix(), User: "mallory@example.com"})
+	require.NoError(t, err)
+	p, err := identifyPrincipal(requestWithCookie(sessionCookie, denied))
+	require.NoError(t, err)
+	assert.True(t, p.policy.DenyCreate)
+
+	// Logout removes the session
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL+"/auth/logout", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	code, _ = get("/api/isWritable")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func requestWithCookie(name, value string) *http.Request {
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: name, Value: value})
+	return req
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"crypto/sha512"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,7 +21,36 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// States of a secret as reported by Status
+const (
+	StatusExpired = "expired"
+	StatusPending = "pending"
+	StatusRead    = "read"
+)
+
 type (
+	// CreateOption modifies the parameters the secret is created with
+	CreateOption func(*createRequest)
+
+	// FetchOption modifies the parameters the secret is revealed with
+	FetchOption func(*revealRequest)
+
+	// CreateResult contains the information returned by the instance
+	// when creating a secret
+	CreateResult struct {
+		// DeletionToken can be passed to Delete in order to destroy the
+		// secret before it has been read
+		DeletionToken string `json:"deletion_token,omitempty"`
+		// ExpiresAt is the zero time for secrets without expiry
+		ExpiresAt time.Time `json:"expires_at"`
+		// SecretURL contains the URL to share with the recipient
+		SecretURL string `json:"secret_url"`
+		// StatusToken can be passed to Status in order to check whether
+		// the secret has been read, it is empty if the instance does not
+		// track the status of secrets
+		StatusToken string `json:"status_token,omitempty"`
+	}
+
 	// HTTPClientIntf describes a minimal interface to be fulfilled
 	// by the given HTTP client. This can be used for mocking and to
 	// pass in authenticated clients
@@ -28,8 +58,42 @@ type (
 		// Do is the expected method on the HTTP client to do the request
 		Do(*http.Request) (*http.Response, error)
 	}
+
+	// SecretStatus contains the status of a secret as reported by the
+	// instance
+	SecretStatus struct {
+		// State is one of StatusPending, StatusRead or StatusExpired
+		State string `json:"status"`
+		// ExpiresAt contains the time the secret expires (or expired)
+		// and is the zero time if it has no expiry or has been read
+		ExpiresAt time.Time `json:"expires_at,omitzero"`
+		// ReadAt contains the time the last view of the secret was
+		// consumed
+		ReadAt time.Time `json:"read_at,omitzero"`
+		// RemainingViews contains the number of views left for a
+		// pending secret
+		RemainingViews int `json:"remaining_views,omitempty"`
+	}
+
+	createRequest struct {
+		CallbackURL string `json:"callback_url,omitempty"`
+		MaxViews    int    `json:"max_views,omitempty"`
+		PIN         string `json:"pin,omitempty"`
+		Secret      string `json:"secret"` //#nosec:G117 // This application works with secrets
+	}
+
+	revealRequest struct {
+		PIN         string `json:"pin,omitempty"`
+		RevealNonce string `json:"reveal_nonce"`
+	}
+
+	statusError int
 )
 
+// ErrPINRequired signalizes the secret is protected by a PIN which
+// needs to be passed to Fetch using WithFetchPIN
+var ErrPINRequired = errors.New("secret requires a pin")
+
 // HTTPClient defines the client to use for create and fetch requests
 // and can be overwritten to provide authentication
 var HTTPClient HTTPClientIntf = http.DefaultClient
@@ -66,6 +130,34 @@ func init() {
 	Logger = logrus.NewEntry(l)
 }
 
+// WithCallbackURL requests signed webhook events to be sent to the
+// given URL when the secret is read or expires unread. The instance
+// rejects URLs not present in its allowlist.
+func WithCallbackURL(u string) CreateOption {
+	return func(o *createRequest) { o.CallbackURL = u }
+}
+
+// WithFetchPIN passes the PIN required to reveal a PIN protected
+// secret. Wrong PINs are counted and the instance destroys the secret
+// after too many of them.
+func WithFetchPIN(pin string) FetchOption {
+	return func(o *revealRequest) { o.PIN = pin }
+}
+
+// WithPIN protects the secret with a PIN the recipient needs to know
+// in addition to the URL. The PIN is not part of the URL and should
+// be transmitted through another channel.
+func WithPIN(pin string) CreateOption {
+	return func(o *createRequest) { o.PIN = pin }
+}
+
+// WithMaxViews allows the secret to be read the given number of times
+// before it is destroyed. The instance might reject values above its
+// configured limit.
+func WithMaxViews(n int) CreateOption {
+	return func(o *createRequest) { o.MaxViews = n }
+}
+
 // Create serializes the secret and creates a new secret on the
 // instance given by its URL.
 //
@@ -74,27 +166,39 @@ func init() {
 // expireIn parameter zero value can be used to use server-default.
 //
 // So for OTS.fyi you'd use `New("https://ots.fyi/")`
-func Create(instanceURL string, secret Secret, expireIn time.Duration) (string, time.Time, error) {
+//
+// Use CreateSecret to get all information returned by the instance.
+func Create(instanceURL string, secret Secret, expireIn time.Duration, opts ...CreateOption) (string, time.Time, error) {
+	res, err := CreateSecret(instanceURL, secret, expireIn, opts...)
+	return res.SecretURL, res.ExpiresAt, err
+}
+
+// CreateSecret works like Create but returns all information about
+// the created secret including the token to delete it.
+func CreateSecret(instanceURL string, secret Secret, expireIn time.Duration, opts ...CreateOption) (CreateResult, error) {
 	u, err := url.Parse(instanceURL)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("parsing instance URL: %w", err)
+		return CreateResult{}, fmt.Errorf("parsing instance URL: %w", err)
 	}
 
 	pass, err := genPass()
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("generating password: %w", err)
+		return CreateResult{}, fmt.Errorf("generating password: %w", err)
 	}
 
 	data, err := secret.serialize(pass)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("serializing data: %w", err)
+		return CreateResult{}, fmt.Errorf("serializing data: %w", err)
+	}
+
+	payload := createRequest{Secret: string(data)}
+	for _, opt := range opts {
+		opt(&payload)
 	}
 
 	body := new(bytes.Buffer)
-	if err = json.NewEncoder(body).Encode(struct {
-		Secret string `json:"secret"` //#nosec:G117 // This application works with secrets
-	}{Secret: string(data)}); err != nil {
-		return "", time.Time{}, fmt.Errorf("encoding request payload: %w", err)
+	if err = json.NewEncoder(body).Encode(payload); err != nil {
+		return CreateResult{}, fmt.Errorf("encoding request payload: %w", err)
 	}
 
 	createURL := u.JoinPath(strings.Join([]string{".", "api", "create"}, "/"))
@@ -109,38 +213,85 @@ func Create(instanceURL string, secret Secret, expireIn time.Duration) (string,
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, createURL.String(), body)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("creating request: %w", err)
+		return CreateResult{}, fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", UserAgent)
 
 	resp, err := HTTPClient.Do(req)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("executing request: %w", err)
+		return CreateResult{}, fmt.Errorf("executing request: %w", err)
 	}
 	defer resp.Body.Close() //nolint:errcheck // possible leaked-fd, lib should not log, potential short-lived leak
 
 	if resp.StatusCode != http.StatusCreated {
 		respBody, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return "", time.Time{}, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+			return CreateResult{}, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
 		}
-		return "", time.Time{}, fmt.Errorf("unexpected HTTP status %d (%s)", resp.StatusCode, respBody)
+		return CreateResult{}, fmt.Errorf("unexpected HTTP status %d (%s)", resp.StatusCode, respBody)
 	}
 
-	var payload struct {
-		ExpiresAt time.Time `json:"expires_at"`
-		SecretID  string    `json:"secret_id"`
-		Success   bool      `json:"success"`
+	var created struct {
+		DeletionToken string    `json:"deletion_token"`
+		ExpiresAt     time.Time `json:"expires_at"`
+		SecretID      string    `json:"secret_id"`
+		StatusToken   string    `json:"status_token"`
+		Success       bool      `json:"success"`
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return "", time.Time{}, fmt.Errorf("decoding response: %w", err)
+	if err = json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return CreateResult{}, fmt.Errorf("decoding response: %w", err)
 	}
 
-	u.Fragment = strings.Join([]string{payload.SecretID, pass}, "|")
+	u.Fragment = strings.Join([]string{created.SecretID, pass}, "|")
 
-	return u.String(), payload.ExpiresAt, nil
+	return CreateResult{
+		DeletionToken: created.DeletionToken,
+		ExpiresAt:     created.ExpiresAt,
+		SecretURL:     u.String(),
+		StatusToken:   created.StatusToken,
+	}, nil
+}
+
+// Delete destroys the secret given by its URL (as returned by Create)
+// using the deletion token returned by CreateSecret. This only works
+// as long as the secret has not been read.
+func Delete(secretURL, deletionToken string) error {
+	u, secretID, _, err := parseSecretURL(secretURL)
+	if err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	if err = json.NewEncoder(body).Encode(struct {
+		DeletionToken string `json:"deletion_token"`
+	}{DeletionToken: deletionToken}); err != nil {
+		return fmt.Errorf("encoding request payload: %w", err)
+	}
+
+	deleteURL := u.JoinPath(strings.Join([]string{".", "api", "delete", secretID}, "/")).String()
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, deleteURL, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // possible leaked-fd, lib should not log, potential short-lived leak
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	return nil
 }
 
 // Fetch retrieves a secret by its given URL. The URL given must
@@ -149,51 +300,166 @@ func Create(instanceURL string, secret Secret, expireIn time.Duration) (string,
 //
 // The object returned will always be an OTSMeta object even in case
 // the secret is a plain secret without attachments.
-func Fetch(secretURL string) (s Secret, err error) {
-	u, err := url.Parse(secretURL)
+//
+// The secret is revealed in two steps: Its metadata is fetched first
+// without consuming it, afterwards the nonce from the metadata is used
+// to consume it. Instances not supporting the reveal API are read
+// through the legacy API consuming the secret in a single request.
+func Fetch(secretURL string, opts ...FetchOption) (s Secret, err error) {
+	u, secretID, pass, err := parseSecretURL(secretURL)
 	if err != nil {
-		return s, fmt.Errorf("parsing secret URL: %w", err)
+		return s, err
 	}
 
-	fragment, err := url.QueryUnescape(u.Fragment)
+	revealURL := u.JoinPath(strings.Join([]string{".", "api", "reveal", secretID}, "/")).String()
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+
+	// Fetching the metadata does not consume the secret but yields the
+	// nonce required to reveal it
+	var (
+		info struct {
+			PINRequired bool   `json:"pin_required"`
+			RevealNonce string `json:"reveal_nonce"`
+		}
+		payload struct {
+			Secret string `json:"secret"` //#nosec:G117 // This application works with secrets
+		}
+		status statusError
+	)
+
+	switch err = doJSON(ctx, http.MethodGet, revealURL, nil, &info); {
+	case errors.As(err, &status) && (status == http.StatusNotFound || status == http.StatusMethodNotAllowed):
+		// Instance does not know the reveal API (or the secret does not
+		// exist which is reported by the legacy API the same way)
+		getURL := u.JoinPath(strings.Join([]string{".", "api", "get", secretID}, "/")).String()
+		if err = doJSON(ctx, http.MethodGet, getURL, nil, &payload); err != nil {
+			return s, fmt.Errorf("fetching secret: %w", err)
+		}
+
+	case err != nil:
+		return s, fmt.Errorf("fetching secret metadata: %w", err)
+
+	default:
+		reveal := revealRequest{RevealNonce: info.RevealNonce}
+		for _, opt := range opts {
+			opt(&reveal)
+		}
+
+		if info.PINRequired && reveal.PIN == "" {
+			return s, ErrPINRequired
+		}
+
+		if err = doJSON(ctx, http.MethodPost, revealURL, reveal, &payload); err != nil {
+			return s, fmt.Errorf("revealing secret: %w", err)
+		}
+	}
+
+	if err = s.read([]byte(payload.Secret), pass); err != nil {
+		return s, fmt.Errorf("decoding secret: %w", err)
+	}
+
+	return s, nil
+}
+
+// Status retrieves the status of the secret given by its URL (as
+// returned by Create) using the status token returned by CreateSecret
+// without revealing or consuming the secret
+func Status(secretURL, statusToken string) (status SecretStatus, err error) {
+	u, secretID, _, err := parseSecretURL(secretURL)
 	if err != nil {
-		return s, fmt.Errorf("unescaping fragment: %w", err)
+		return status, err
 	}
-	fragmentParts := strings.SplitN(fragment, "|", 2)
 
-	fetchURL := u.JoinPath(strings.Join([]string{".", "api", "get", fragmentParts[0]}, "/")).String()
+	statusURL := u.JoinPath(strings.Join([]string{".", "api", "status", secretID}, "/")).String()
 	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fetchURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return status, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+statusToken)
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
-		return s, fmt.Errorf("creating request: %w", err)
+		return status, fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // possible leaked-fd, lib should not log, potential short-lived leak
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("decoding response body: %w", err)
+	}
+
+	return status, nil
+}
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d", int(s))
+}
+
+// doJSON executes a request with the optional JSON body and decodes
+// the JSON response into out after ensuring a 200 status
+func doJSON(ctx context.Context, method, target string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(in); err != nil {
+			return fmt.Errorf("encoding request payload: %w", err)
+		}
+		body = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 	req.Header.Set("User-Agent", UserAgent)
 
 	resp, err := HTTPClient.Do(req)
 	if err != nil {
-		return s, fmt.Errorf("executing request: %w", err)
+		return fmt.Errorf("executing request: %w", err)
 	}
 	defer resp.Body.Close() //nolint:errcheck // possible leaked-fd, lib should not log, potential short-lived leak
 
 	if resp.StatusCode != http.StatusOK {
-		return s, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+		return statusError(resp.StatusCode)
 	}
 
-	var payload struct {
-		Secret string `json:"secret"` //#nosec:G117 // This application works with secrets
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response body: %w", err)
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return s, fmt.Errorf("decoding response body: %w", err)
+	return nil
+}
+
+// parseSecretURL splits the URL of a secret into the URL of the
+// instance, the ID of the secret and the encryption password
+func parseSecretURL(secretURL string) (u *url.URL, secretID, pass string, err error) {
+	if u, err = url.Parse(secretURL); err != nil {
+		return nil, "", "", fmt.Errorf("parsing secret URL: %w", err)
 	}
 
-	if err = s.read([]byte(payload.Secret), fragmentParts[1]); err != nil {
-		return s, fmt.Errorf("decoding secret: %w", err)
+	fragment, err := url.QueryUnescape(u.Fragment)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("unescaping fragment: %w", err)
 	}
+	u.Fragment = ""
 
-	return s, nil
+	secretID, pass, _ = strings.Cut(fragment, "|")
+	if secretID == "" {
+		return nil, "", "", fmt.Errorf("secret URL does not contain a secret ID")
+	}
+
+	return u, secretID, pass, nil
 }
 
 func genPass() (string, error) {
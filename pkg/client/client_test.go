@@ -1,6 +1,9 @@
 package client
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -35,3 +38,153 @@ func TestIntegration(t *testing.T) {
 
 	assert.Equal(t, s, apiSecret)
 }
+
+func TestCreateWithOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CallbackURL string `json:"callback_url"`
+			MaxViews    int    `json:"max_views"`
+			PIN         string `json:"pin"`
+			Secret      string `json:"secret"`
+		}
+		assert.Equal(t, "/api/create", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "https://example.com/hook", req.CallbackURL)
+		assert.Equal(t, 3, req.MaxViews)
+		assert.Equal(t, "1234", req.PIN)
+		assert.NotEmpty(t, req.Secret)
+
+		w.WriteHeader(http.StatusCreated)
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{"success": true, "secret_id": "foo"}))
+	}))
+	t.Cleanup(srv.Close)
+
+	secretURL, _, err := Create(srv.URL, Secret{Secret: "I'm a secret!"}, 0, WithMaxViews(3), WithCallbackURL("https://example.com/hook"), WithPIN("1234"))
+	require.NoError(t, err)
+	assert.Contains(t, secretURL, "#foo%7C")
+}
+
+func TestDelete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			DeletionToken string `json:"deletion_token"`
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/delete/foo", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		if req.DeletionToken != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	require.NoError(t, Delete(srv.URL+"/#foo%7Cpass", "token"))
+	require.Error(t, Delete(srv.URL+"/#foo%7Cpass", "wrong"))
+	require.Error(t, Delete(srv.URL+"/", "token"), "URL without secret ID")
+}
+
+func TestFetch(t *testing.T) {
+	data, err := Secret{Secret: "I'm a secret!"}.serialize("pass")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/reveal/foo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"success":true,"pin_required":true,"remaining_views":1,"reveal_nonce":"nonce"}`))
+
+		case http.MethodPost:
+			var req struct {
+				PIN         string `json:"pin"`
+				RevealNonce string `json:"reveal_nonce"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.RevealNonce != "nonce" || req.PIN != "1234" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{"success": true, "secret": string(data)}))
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err = Fetch(srv.URL + "/#foo%7Cpass")
+	require.ErrorIs(t, err, ErrPINRequired)
+
+	_, err = Fetch(srv.URL+"/#foo%7Cpass", WithFetchPIN("4321"))
+	require.Error(t, err)
+
+	secret, err := Fetch(srv.URL+"/#foo%7Cpass", WithFetchPIN("1234"))
+	require.NoError(t, err)
+	assert.Equal(t, "I'm a secret!", secret.Secret)
+
+	_, err = Fetch(srv.URL + "/#bar%7Cpass")
+	require.Error(t, err)
+}
+
+func TestFetchLegacy(t *testing.T) {
+	data, err := Secret{Secret: "I'm a secret!"}.serialize("pass")
+	require.NoError(t, err)
+
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/get/foo" {
+				w.WriteHeader(status)
+				return
+			}
+
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{"success": true, "secret": string(data)}))
+		}))
+		t.Cleanup(srv.Close)
+
+		secret, err := Fetch(srv.URL + "/#foo%7Cpass")
+		require.NoError(t, err, "status %d", status)
+		assert.Equal(t, "I'm a secret!", secret.Secret)
+
+		_, err = Fetch(srv.URL + "/#bar%7Cpass")
+		require.Error(t, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEqual(t, "/api/get/foo", r.URL.Path, "must not fall back on other errors")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err = Fetch(srv.URL + "/#foo%7Cpass")
+	require.Error(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/status/foo", r.URL.Path)
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"success":true,"status":"read","read_at":"2026-01-02T03:04:05Z"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	status, err := Status(srv.URL+"/#foo%7Cpass", "token")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRead, status.State)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), status.ReadAt)
+	assert.True(t, status.ExpiresAt.IsZero())
+
+	_, err = Status(srv.URL+"/#foo%7Cpass", "wrong")
+	require.Error(t, err)
+}
@@ -20,6 +20,12 @@ import (
 // 65 MiB * 16/9 (twice 4/3 base64 size increase)
 const defaultMaxSecretSize = 65 * 1024 * 1024 * (16 / 9) // = 115.6MiB
 
+// Secrets can be read this many times at most when not configured
+// otherwise. Multi-view secrets must be enabled by raising
+// MaxSecretViews in order not to weaken the one-time promise of
+// existing instances.
+const defaultMaxSecretViews = 1
+
 type (
 	// Customize holds the structure of the customization file
 	Customize struct {
@@ -34,18 +40,87 @@ type (
 		DisableExpiryOverride bool    `json:"disableExpiryOverride,omitempty" yaml:"disableExpiryOverride"`
 		ExpiryChoices         []int64 `json:"expiryChoices,omitempty" yaml:"expiryChoices"`
 
+		MaxSecretViews int `json:"maxSecretViews,omitempty" yaml:"maxSecretViews"`
+
 		AcceptedFileTypes      string `json:"acceptedFileTypes" yaml:"acceptedFileTypes"`
 		DisableFileAttachment  bool   `json:"disableFileAttachment" yaml:"disableFileAttachment"`
 		MaxAttachmentSizeTotal int64  `json:"maxAttachmentSizeTotal" yaml:"maxAttachmentSizeTotal"`
 
-		MaxSecretSize         int64    `json:"-" yaml:"maxSecretSize"`
-		MetricsAllowedSubnets []string `json:"-" yaml:"metricsAllowedSubnets"`
-		OverlayFSPath         string   `json:"-" yaml:"overlayFSPath"`
-		UseFormalLanguage     bool     `json:"-" yaml:"useFormalLanguage"`
+		Auth                     Auth     `json:"-" yaml:"auth"`
+		BruteForceAllowedSubnets []string `json:"-" yaml:"bruteForceAllowedSubnets"`
+		MaxSecretSize            int64    `json:"-" yaml:"maxSecretSize"`
+		MetricsAllowedSubnets    []string `json:"-" yaml:"metricsAllowedSubnets"`
+		OverlayFSPath            string   `json:"-" yaml:"overlayFSPath"`
+		ProxyProtocolSubnets     []string `json:"-" yaml:"proxyProtocolSubnets"`
+		TrustedProxies           []string `json:"-" yaml:"trustedProxies"`
+		TrustedProxyHeader       string   `json:"-" yaml:"trustedProxyHeader"`
+		UseFormalLanguage        bool     `json:"-" yaml:"useFormalLanguage"`
+		WebhookAllowedURLs       []string `json:"-" yaml:"webhookAllowedURLs"`
 
 		FooterLinks []FooterLink `json:"footerLinks,omitempty" yaml:"footerLinks"`
 	}
 
+	// Auth configures who is allowed to create secrets, reading
+	// secrets never requires authentication
+	Auth struct {
+		// Anonymous is the policy for requests without credentials. If
+		// not set anonymous requests are not restricted unless tokens,
+		// users, OIDC or a proxy are configured, then they are denied.
+		Anonymous *Policy   `yaml:"anonymous"`
+		OIDC      OIDCAuth  `yaml:"oidc"`
+		Proxy     ProxyAuth `yaml:"proxy"`
+		Tokens    []Token   `yaml:"tokens"`
+		Users     []User    `yaml:"users"`
+	}
+
+	// OIDCAuth configures the users logged in through OpenID Connect,
+	// the provider itself is configured through the command-line
+	OIDCAuth struct {
+		// Policy applies to all users not listed in Policies
+		Policy   Policy            `yaml:"policy"`
+		Policies map[string]Policy `yaml:"policies"`
+		// UsernameClaim is the claim of the ID token to identify the
+		// user by (default "email")
+		UsernameClaim string `yaml:"usernameClaim"`
+	}
+
+	// Policy restricts the secrets a principal may create
+	Policy struct {
+		DenyCreate bool `yaml:"denyCreate"`
+		// MaxExpiry limits the expiry of the secret in seconds
+		// (0 = instance limit)
+		MaxExpiry int64 `yaml:"maxExpiry"`
+		// MaxSecretSize limits the size of the encrypted secret in
+		// bytes (0 = instance limit)
+		MaxSecretSize int64 `yaml:"maxSecretSize"`
+	}
+
+	// ProxyAuth trusts the identity header set by a reverse proxy
+	// when the request originates from one of the trusted subnets
+	ProxyAuth struct {
+		Header string `yaml:"header"`
+		// Policy applies to all users not listed in Policies
+		Policy         Policy            `yaml:"policy"`
+		Policies       map[string]Policy `yaml:"policies"`
+		TrustedSubnets []string          `yaml:"trustedSubnets"`
+	}
+
+	// Token is a static API token sent as "Authorization: Token ..."
+	Token struct {
+		Name string `yaml:"name"`
+		// TokenHash is the hex encoded SHA256 hash of the token
+		TokenHash string `yaml:"tokenHash"`
+		Policy    Policy `yaml:"policy"`
+	}
+
+	// User authenticates through HTTP basic auth
+	User struct {
+		Name string `yaml:"name"`
+		// PasswordHash is the bcrypt hash of the password
+		PasswordHash string `yaml:"passwordHash"`
+		Policy       Policy `yaml:"policy"`
+	}
+
 	// FooterLink holds name/url combinations to add as a link in the
 	// footer to i.e. add imprint or privacy policy
 	FooterLink struct {
@@ -104,4 +179,16 @@ func (c *Customize) applyFixes() {
 	if c.MaxSecretSize == 0 {
 		c.MaxSecretSize = defaultMaxSecretSize
 	}
+
+	if c.Auth.OIDC.UsernameClaim == "" {
+		c.Auth.OIDC.UsernameClaim = "email"
+	}
+
+	if c.MaxSecretViews <= 0 {
+		c.MaxSecretViews = defaultMaxSecretViews
+	}
+
+	if c.TrustedProxyHeader == "" {
+		c.TrustedProxyHeader = "X-Forwarded-For"
+	}
 }
@@ -11,9 +11,14 @@ import (
 )
 
 const (
+	metricClientsBanned       = "clients_banned"
+	metricRequestsBlocked     = "requests_blocked"
+	metricRequestsDelayed     = "requests_delayed"
 	metricSecretsCreated      = "secrets_created"
+	metricSecretsDeleted      = "secrets_deleted"
 	metricSecretsRead         = "secrets_read"
 	metricSecretsCreateErrors = "secrets_create_errors"
+	metricSecretsDeleteErrors = "secrets_delete_errors"
 	meticsSecretsReadErrors   = "secrets_read_errors"
 	metricsSecretsStored      = "secrets_stored"
 
@@ -26,9 +31,14 @@ type (
 	// Collector contains all required methods to collect metrics
 	// and to populate them into the Handler
 	Collector struct {
+		clientsBanned       prometheus.Counter
+		requestsBlocked     prometheus.Counter
+		requestsDelayed     prometheus.Counter
 		secretsCreated      prometheus.Counter
+		secretsDeleted      prometheus.Counter
 		secretsRead         prometheus.Counter
 		secretsCreateErrors *prometheus.CounterVec
+		secretsDeleteErrors *prometheus.CounterVec
 		secretsReadErrors   *prometheus.CounterVec
 		secretsStored       prometheus.Gauge
 	}
@@ -40,12 +50,36 @@ func Handler() http.Handler { return promhttp.Handler() }
 // New creates a new Collector and registers the metrics
 func New() *Collector {
 	return &Collector{
+		clientsBanned: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricClientsBanned,
+			Help:      "number of clients temporarily banned for reading too many unknown secrets",
+		}),
+
+		requestsBlocked: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricRequestsBlocked,
+			Help:      "number of read requests rejected because the client is banned",
+		}),
+
+		requestsDelayed: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricRequestsDelayed,
+			Help:      "number of read requests delayed because the client read too many unknown secrets",
+		}),
+
 		secretsCreated: promauto.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      metricSecretsCreated,
 			Help:      "number of successfully created secrets",
 		}),
 
+		secretsDeleted: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricSecretsDeleted,
+			Help:      "number of secrets destroyed by their creator before being read",
+		}),
+
 		secretsRead: promauto.NewCounter(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      metricSecretsRead,
@@ -58,6 +92,12 @@ func New() *Collector {
 			Help:      "number of errors on secret creation for each reason",
 		}, []string{labelReason}),
 
+		secretsDeleteErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      metricSecretsDeleteErrors,
+			Help:      "number of errors on secret deletion for each reason",
+		}, []string{labelReason}),
+
 		secretsReadErrors: promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      meticsSecretsReadErrors,
@@ -67,11 +107,23 @@ func New() *Collector {
 		secretsStored: promauto.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      metricsSecretsStored,
-			Help:      "number of secrets currently held in the backend store",
+			Help:      "number of secrets currently held in the backend store (including status records of read or expired secrets)",
 		}),
 	}
 }
 
+// CountClientBanned signalizes a client has been banned from reading
+// secrets
+func (c Collector) CountClientBanned() { c.clientsBanned.Inc() }
+
+// CountRequestBlocked signalizes a request of a banned client has
+// been rejected
+func (c Collector) CountRequestBlocked() { c.requestsBlocked.Inc() }
+
+// CountRequestDelayed signalizes a request has been delayed before
+// being handled
+func (c Collector) CountRequestDelayed() { c.requestsDelayed.Inc() }
+
 // CountSecretCreateError signalizes an error occurred during secret
 // creation. The reason must not be the error.Error() but a simple
 // static string describing the error.
@@ -82,6 +134,16 @@ func (c Collector) CountSecretCreateError(reason string) {
 // CountSecretCreated signalizes a secret has successfully been created
 func (c Collector) CountSecretCreated() { c.secretsCreated.Inc() }
 
+// CountSecretDeleted signalizes a secret has been destroyed by its creator
+func (c Collector) CountSecretDeleted() { c.secretsDeleted.Inc() }
+
+// CountSecretDeleteError signalizes an error occurred during secret
+// deletion. The reason must not be the error.Error() but a simple
+// static string describing the error.
+func (c Collector) CountSecretDeleteError(reason string) {
+	c.secretsDeleteErrors.WithLabelValues(reason).Inc()
+}
+
 // CountSecretRead signalizes a secret has successfully been read and destroyed
 func (c Collector) CountSecretRead() { c.secretsRead.Inc() }
 
@@ -92,6 +154,12 @@ func (c Collector) CountSecretReadError(reason string) {
 	c.secretsReadErrors.WithLabelValues(reason).Inc()
 }
 
+// AdjustSecretsCount modifies the current amount of secrets stored in
+// the backend storage by the given delta until the next UpdateSecretsCount
+func (c Collector) AdjustSecretsCount(delta int64) {
+	c.secretsStored.Add(float64(delta))
+}
+
 // UpdateSecretsCount sets the current amount of secrets stored in the
 // backend storage
 func (c Collector) UpdateSecretsCount(count int64) {
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	guardBaseDelay = 250 * time.Millisecond
+	guardMaxDelay  = 5 * time.Second
+)
+
+type (
+	// Guard protects against clients guessing keys by counting their
+	// failures, delaying their requests and banning them temporarily
+	Guard struct {
+		config GuardConfig
+		store  FailureStore
+	}
+
+	// GuardConfig configures when the Guard delays or bans clients,
+	// thresholds set to 0 disable the respective protection
+	GuardConfig struct {
+		// BanAfter is the number of failures within the Window after
+		// which the client is banned for BanDuration
+		BanAfter    int64
+		BanDuration time.Duration
+		// DelayAfter is the number of failures within the Window after
+		// which every request is delayed, the delay doubles with each
+		// further failure
+		DelayAfter int64
+		Window     time.Duration
+	}
+
+	// FailureStore keeps the failures and bans of the clients
+	FailureStore interface {
+		// AddFailure counts a failure for the key and returns the number
+		// of failures counted within the window starting with the first
+		AddFailure(ctx context.Context, key string, window time.Duration) (int64, error)
+		// Ban blocks the key for the given duration and resets its
+		// failures
+		Ban(ctx context.Context, key string, d time.Duration) error
+		// State returns the failures counted in the current window and
+		// the remaining duration of the ban
+		State(ctx context.Context, key string) (failures int64, banned time.Duration, err error)
+	}
+)
+
+// NewGuard creates a Guard keeping its state in the given store
+func NewGuard(config GuardConfig, store FailureStore) *Guard {
+	return &Guard{config: config, store: store}
+}
+
+// Check returns the remaining ban of the key and otherwise the delay
+// to apply before handling the request
+func (g Guard) Check(ctx context.Context, key string) (banned, delay time.Duration, err error) {
+	failures, banned, err := g.store.State(ctx, key)
+	if err != nil {
+		return 0, 0, fmt.Errorf("fetching state: %w", err)
+	}
+
+	if banned > 0 {
+		return banned, 0, nil
+	}
+
+	return 0, g.config.delay(failures), nil
+}
+
+// Fail counts a failure for the key and bans it when reaching the
+// threshold. The return value signals whether the key has been banned.
+func (g Guard) Fail(ctx context.Context, key string) (bool, error) {
+	failures, err := g.store.AddFailure(ctx, key, g.config.Window)
+	if err != nil {
+		return false, fmt.Errorf("adding failure: %w", err)
+	}
+
+	if g.config.BanAfter <= 0 || failures < g.config.BanAfter {
+		return false, nil
+	}
+
+	if err = g.store.Ban(ctx, key, g.config.BanDuration); err != nil {
+		return false, fmt.Errorf("banning: %w", err)
+	}
+
+	return true, nil
+}
+
+// delay returns the delay for the given number of failures
+func (c GuardConfig) delay(failures int64) time.Duration {
+	if c.DelayAfter <= 0 || failures < c.DelayAfter {
+		return 0
+	}
+
+	// Limit the shift to not overflow, the max delay is reached long before
+	return min(guardBaseDelay<<min(failures-c.DelayAfter, 16), guardMaxDelay) //nolint:mnd // See comment
+}
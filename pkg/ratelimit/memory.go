@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Buckets not used for this long are refilled and therefore dropped
+const memorySweepInterval = time.Minute
+
+type memoryLimiter struct {
+	buckets   map[string]bucket
+	lastSweep time.Time
+	limit     Limit
+	lock      sync.Mutex
+}
+
+// NewMemory creates a Limiter holding the buckets in memory which is
+// suitable for single instance setups
+func NewMemory(limit Limit) Limiter {
+	return &memoryLimiter{
+		buckets:   map[string]bucket{},
+		lastSweep: time.Now(),
+		limit:     limit,
+	}
+}
+
+func (m *memoryLimiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > memorySweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok, wait := m.limit.take(m.buckets[key], now)
+	m.buckets[key] = b
+
+	return ok, wait, nil
+}
+
+// sweep removes buckets which would be full again as they are
+// indistinguishable from new ones
+func (m *memoryLimiter) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.update) >= m.limit.Interval {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.lastSweep = now
+}
+
+type (
+	memoryFailures struct {
+		entries   map[string]failureEntry
+		lastSweep time.Time
+		lock      sync.Mutex
+	}
+
+	failureEntry struct {
+		bannedUntil time.Time
+		count       int64
+		windowEnd   time.Time
+	}
+)
+
+// NewMemoryFailureStore creates a FailureStore holding the failures in
+// memory which is suitable for single instance setups
+func NewMemoryFailureStore() FailureStore {
+	return &memoryFailures{
+		entries:   map[string]failureEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *memoryFailures) AddFailure(_ context.Context, key string, window time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > memorySweepInterval {
+		m.sweep(now)
+	}
+
+	e := m.entries[key]
+	if !e.windowEnd.After(now) {
+		e.count = 0
+		e.windowEnd = now.Add(window)
+	}
+	e.count++
+	m.entries[key] = e
+
+	return e.count, nil
+}
+
+func (m *memoryFailures) Ban(_ context.Context, key string, d time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entries[key] = failureEntry{bannedUntil: time.Now().Add(d)}
+	return nil
+}
+
+func (m *memoryFailures) State(_ context.Context, key string) (int64, time.Duration, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var (
+		e   = m.entries[key]
+		now = time.Now()
+	)
+
+	if !e.windowEnd.After(now) {
+		e.count = 0
+	}
+
+	return e.count, max(e.bannedUntil.Sub(now), 0), nil
+}
+
+// sweep removes entries having neither a window nor a ban running
+func (m *memoryFailures) sweep(now time.Time) {
+	for key, e := range m.entries {
+		if !e.windowEnd.After(now) && !e.bannedUntil.After(now) {
+			delete(m.entries, key)
+		}
+	}
+
+	m.lastSweep = now
+}
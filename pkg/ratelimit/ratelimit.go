@@ -0,0 +1,80 @@
+// Package ratelimit implements token-bucket rate limiting with the
+// buckets held in memory or shared between instances through Redis
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Limit describes a bucket holding Burst tokens which is refilled
+	// by Burst tokens every Interval
+	Limit struct {
+		Burst    int
+		Interval time.Duration
+	}
+
+	// Limiter takes tokens from the buckets identified by their keys
+	Limiter interface {
+		// Allow takes a token from the bucket of the key. If the bucket
+		// is empty the request must be rejected and may be retried after
+		// the returned duration.
+		Allow(ctx context.Context, key string) (ok bool, retryAfter time.Duration, err error)
+	}
+
+	// bucket holds the state of a token bucket between requests
+	bucket struct {
+		tokens float64
+		update time.Time
+	}
+)
+
+// ParseLimit parses a limit given as "<count>/<interval>" (i.e. "10/1m"
+// for a burst of 10 requests refilled over one minute). The buckets are
+// refilled with millisecond precision, therefore the interval must be
+// at least one millisecond.
+func ParseLimit(s string) (Limit, error) {
+	count, interval, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, errors.New(`limit must be given as "<count>/<interval>"`)
+	}
+
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("invalid count %q", count)
+	}
+
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < time.Millisecond {
+		return Limit{}, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	return Limit{Burst: burst, Interval: d}, nil
+}
+
+// rate returns the tokens added to the bucket per millisecond
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / float64(l.Interval.Milliseconds())
+}
+
+// take refills the bucket for the time passed since its last update
+// and takes a token from it
+func (l Limit) take(b bucket, now time.Time) (bucket, bool, time.Duration) {
+	tokens := float64(l.Burst)
+	if !b.update.IsZero() {
+		tokens = math.Min(tokens, b.tokens+float64(now.Sub(b.update).Milliseconds())*l.rate())
+	}
+
+	if tokens < 1 {
+		wait := time.Duration(math.Ceil((1-tokens)/l.rate())) * time.Millisecond
+		return bucket{tokens: tokens, update: now}, false, wait
+	}
+
+	return bucket{tokens: tokens - 1, update: now}, true, 0
+}
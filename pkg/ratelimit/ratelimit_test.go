@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	l, err := ParseLimit("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Burst: 10, Interval: time.Minute}, l)
+
+	l, err = ParseLimit("1/1ms")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, l.rate())
+
+	for _, in := range []string{"", "10", "0/1m", "-1/1m", "foo/1m", "10/foo", "10/0s", "10/999us"} {
+		_, err = ParseLimit(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestTake(t *testing.T) {
+	var (
+		l   = Limit{Burst: 2, Interval: 2 * time.Second}
+		now = time.Now()
+		b   bucket
+		ok  bool
+		d   time.Duration
+	)
+
+	for range 2 {
+		b, ok, _ = l.take(b, now)
+		assert.True(t, ok, "burst must pass")
+	}
+
+	b, ok, d = l.take(b, now)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, d, "one token is added per second")
+
+	b, ok, _ = l.take(b, now.Add(time.Second))
+	assert.True(t, ok, "bucket must be refilled")
+
+	_, ok, _ = l.take(b, now.Add(time.Hour))
+	assert.True(t, ok)
+}
+
+func TestLimiters(t *testing.T) {
+	limit := Limit{Burst: 2, Interval: time.Hour}
+
+	mr := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for name, l := range map[string]Limiter{
+		"memory": NewMemory(limit),
+		"redis":  NewRedis(conn, "test", limit),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for range 2 {
+				ok, _, err := l.Allow(ctx, "a")
+				require.NoError(t, err)
+				assert.True(t, ok)
+			}
+
+			ok, wait, err := l.Allow(ctx, "a")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.InDelta(t, 30*time.Minute, wait, float64(time.Second))
+
+			ok, _, err = l.Allow(ctx, "b")
+			require.NoError(t, err)
+			assert.True(t, ok, "buckets must be separated by key")
+		})
+	}
+
+	assert.True(t, mr.Exists("test:a"))
+	assert.Greater(t, mr.TTL("test:a"), time.Duration(0), "bucket must expire")
+}
+
+func TestGuardDelay(t *testing.T) {
+	c := GuardConfig{DelayAfter: 2}
+
+	assert.Zero(t, c.delay(1))
+	assert.Equal(t, guardBaseDelay, c.delay(2))
+	assert.Equal(t, 2*guardBaseDelay, c.delay(3))
+	assert.Equal(t, guardMaxDelay, c.delay(100))
+	assert.Equal(t, guardMaxDelay, c.delay(1<<40), "shift must not overflow")
+
+	assert.Zero(t, GuardConfig{}.delay(100), "delay must be disabled")
+}
+
+func TestGuard(t *testing.T) {
+	config := GuardConfig{BanAfter: 3, BanDuration: time.Hour, DelayAfter: 2, Window: time.Minute}
+
+	mr := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for name, s := range map[string]FailureStore{
+		"memory": NewMemoryFailureStore(),
+		"redis":  NewRedisFailureStore(conn, "test"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx = context.Background()
+				g   = NewGuard(config, s)
+			)
+
+			banned, delay, err := g.Check(ctx, "a")
+			require.NoError(t, err)
+			assert.Zero(t, banned)
+			assert.Zero(t, delay)
+
+			for range 2 {
+				ban, err := g.Fail(ctx, "a")
+				require.NoError(t, err)
+				assert.False(t, ban)
+			}
+
+			_, delay, err = g.Check(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, guardBaseDelay, delay)
+
+			ban, err := g.Fail(ctx, "a")
+			require.NoError(t, err)
+			assert.True(t, ban)
+
+			banned, delay, err = g.Check(ctx, "a")
+			require.NoError(t, err)
+			assert.InDelta(t, time.Hour, banned, float64(time.Second))
+			assert.Zero(t, delay)
+
+			banned, _, err = g.Check(ctx, "b")
+			require.NoError(t, err)
+			assert.Zero(t, banned, "keys must be separated")
+		})
+	}
+
+	assert.False(t, mr.Exists("test:a:failures"), "failures must be reset on ban")
+	assert.Greater(t, mr.TTL("test:a:ban"), time.Duration(0), "ban must expire")
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// takeScript implements Limit.take atomically on a hash holding the
+// tokens and the time of the last update. The time is passed in by the
+// instances so their clocks should be reasonably in sync.
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'update')
+local tokens = burst
+if state[1] and state[2] then
+  tokens = math.min(burst, tonumber(state[1]) + math.max(0, now - tonumber(state[2])) * rate)
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'update', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+
+return {allowed, wait}
+`)
+
+type redisLimiter struct {
+	conn   redis.UniversalClient
+	limit  Limit
+	prefix string
+}
+
+// NewRedis creates a Limiter sharing the buckets between all instances
+// using the same Redis and prefix
+func NewRedis(conn redis.UniversalClient, prefix string, limit Limit) Limiter {
+	return redisLimiter{conn: conn, limit: limit, prefix: prefix}
+}
+
+func (r redisLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	res, err := takeScript.Run(ctx, r.conn,
+		[]string{strings.Join([]string{r.prefix, key}, ":")},
+		r.limit.Burst, r.limit.rate(), time.Now().UnixMilli(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("executing script: %w", err)
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// addFailureScript increments the failures and starts the window with
+// the first failure
+var addFailureScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return failures
+`)
+
+type redisFailures struct {
+	conn   redis.UniversalClient
+	prefix string
+}
+
+// NewRedisFailureStore creates a FailureStore sharing the failures
+// between all instances using the same Redis and prefix
+func NewRedisFailureStore(conn redis.UniversalClient, prefix string) FailureStore {
+	return redisFailures{conn: conn, prefix: prefix}
+}
+
+func (r redisFailures) AddFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	failures, err := addFailureScript.Run(ctx, r.conn, []string{r.key(key, "failures")}, window.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("executing script: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (r redisFailures) Ban(ctx context.Context, key string, d time.Duration) error {
+	// The keys are not necessarily on the same node in a cluster so
+	// this cannot be a transaction
+	if err := r.conn.Set(ctx, r.key(key, "ban"), 1, d).Err(); err != nil {
+		return fmt.Errorf("setting ban: %w", err)
+	}
+
+	if err := r.conn.Del(ctx, r.key(key, "failures")).Err(); err != nil {
+		return fmt.Errorf("resetting failures: %w", err)
+	}
+
+	return nil
+}
+
+func (r redisFailures) State(ctx context.Context, key string) (int64, time.Duration, error) {
+	failures, err := r.conn.Get(ctx, r.key(key, "failures")).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, fmt.Errorf("getting failures: %w", err)
+	}
+
+	banned, err := r.conn.PTTL(ctx, r.key(key, "ban")).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting ban: %w", err)
+	}
+
+	// Missing keys are reported as negative durations
+	return failures, max(banned, 0), nil
+}
+
+func (r redisFailures) key(key, kind string) string {
+	return strings.Join([]string{r.prefix, key, kind}, ":")
+}
@@ -0,0 +1,243 @@
+// Package bbolt implements an embedded bbolt key/value database backed
+// storage for secrets which does not require any external process
+package bbolt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+const (
+	dbFileMode  = 0o600
+	openTimeout = 5 * time.Second
+)
+
+var (
+	bucketSecrets = []byte("secrets")
+
+	// errUnchanged rolls back write transactions of updates which
+	// turned out to keep the secret
+	errUnchanged = errors.New("secret unchanged")
+)
+
+type (
+	storageBBolt struct {
+		storage.ExpiryNotifications
+
+		db          *bolt.DB
+		storePruner *storage.Periodic
+	}
+)
+
+// New returns a new bbolt backed storage using the database file given
+// through the BBOLT_PATH environment variable
+func New() (storage.Storage, error) {
+	if os.Getenv("BBOLT_PATH") == "" {
+		return nil, fmt.Errorf("BBOLT_PATH environment variable not set")
+	}
+
+	db, err := bolt.Open(os.Getenv("BBOLT_PATH"), dbFileMode, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSecrets)
+		return err //nolint:wrapcheck // Wrapped outside the transaction
+	}); err != nil {
+		return nil, fmt.Errorf("creating bucket: %w", err)
+	}
+
+	s := &storageBBolt{
+		db: db,
+	}
+
+	s.storePruner = storage.RunPeriodic(time.Minute, s.pruneStore)
+
+	return s, nil
+}
+
+func (s *storageBBolt) Count(context.Context) (n int64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		n = int64(tx.Bucket(bucketSecrets).Stats().KeyN)
+		return nil
+	})
+
+	return n, err //nolint:wrapcheck // View only returns our own nil error
+}
+
+func (s *storageBBolt) Create(_ context.Context, secret storage.Secret, expireIn time.Duration) (string, error) {
+	id := uuid.Must(uuid.NewV4()).String()
+
+	secret.Expiry = time.Time{}
+	if expireIn > 0 {
+		secret.Expiry = time.Now().Add(expireIn)
+	}
+
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return "", fmt.Errorf("encoding secret: %w", err)
+	}
+
+	if err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSecrets).Put([]byte(id), data) //nolint:wrapcheck // Wrapped outside the transaction
+	}); err != nil {
+		return "", fmt.Errorf("writing secret: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *storageBBolt) Update(_ context.Context, id string, fn storage.UpdateFunc) (storage.Secret, error) {
+	// Most updates only read the secret (i.e. status polling), these
+	// are served by a read transaction neither waiting for the single
+	// writer nor syncing the database to disk
+	secret, action, err := s.update(s.db.View, id, fn)
+	if err != nil || action == storage.UpdateActionKeep {
+		return secret, err
+	}
+
+	// Reading and writing happens within the same write transaction
+	// and bbolt only allows one of them at a time so no other reader
+	// can modify the secret in between. The secret might have changed
+	// since the read transaction so the UpdateFunc is called again.
+	secret, _, err = s.update(s.db.Update, id, fn)
+	return secret, err
+}
+
+// update executes the UpdateFunc within the given transaction, the
+// resulting action is only applied within a write transaction
+func (*storageBBolt) update(txFn func(func(*bolt.Tx) error) error, id string, fn storage.UpdateFunc) (secret storage.Secret, action storage.UpdateAction, err error) {
+	err = txFn(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSecrets)
+
+		data := b.Get([]byte(id))
+		if data == nil {
+			return storage.ErrSecretNotFound
+		}
+
+		if err := json.Unmarshal(data, &secret); err != nil {
+			return fmt.Errorf("decoding secret: %w", err)
+		}
+
+		// Still check to see if the secret has expired in order to prevent a
+		// race condition where a secret has expired but the store pruner has
+		// not yet been invoked.
+		if secret.Expired() {
+			return storage.ErrSecretNotFound
+		}
+
+		var err error
+		if action, err = fn(&secret); err != nil {
+			return err
+		}
+
+		if !tx.Writable() {
+			return nil
+		}
+
+		switch action {
+		case storage.UpdateActionStore:
+			if data, err = json.Marshal(secret); err != nil {
+				return fmt.Errorf("encoding secret: %w", err)
+			}
+			return b.Put([]byte(id), data) //nolint:wrapcheck // Wrapped outside the transaction
+
+		case storage.UpdateActionDelete:
+			return b.Delete([]byte(id)) //nolint:wrapcheck // Wrapped outside the transaction
+		}
+
+		// Nothing changed since the read transaction, rolling back
+		// spares syncing the database
+		return errUnchanged
+	})
+
+	switch {
+	case errors.Is(err, errUnchanged):
+		return secret, action, nil
+
+	case err != nil:
+		return storage.Secret{}, action, fmt.Errorf("updating secret: %w", err)
+	}
+
+	return secret, action, nil
+}
+
+func (s *storageBBolt) pruneStore() {
+	var contentExpired []string
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		var (
+			c       = tx.Bucket(bucketSecrets).Cursor()
+			expired [][]byte
+		)
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var secret storage.Secret
+			if err := json.Unmarshal(v, &secret); err != nil {
+				logrus.WithError(err).WithField("id", string(k)).Error("decoding secret for pruning")
+				continue
+			}
+
+			switch {
+			case secret.Expired():
+				// Keys must not be deleted while iterating the cursor
+				expired = append(expired, k)
+
+			case secret.CallbackURL != "" && secret.ExpiredUnread():
+				contentExpired = append(contentExpired, string(k))
+			}
+		}
+
+		for _, k := range expired {
+			if err := tx.Bucket(bucketSecrets).Delete(k); err != nil {
+				return fmt.Errorf("deleting expired secret: %w", err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		logrus.WithError(err).Error("pruning expired secrets")
+	}
+
+	for _, id := range contentExpired {
+		if err := s.NotifyIfExpired(context.Background(), s, id); err != nil {
+			logrus.WithError(err).WithField("id", id).Error("notifying about expired secret")
+		}
+	}
+}
+
+// Ping checks the database to be readable
+func (s *storageBBolt) Ping(context.Context) error {
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketSecrets) == nil {
+			return fmt.Errorf("bucket %q not found", bucketSecrets)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("reading database: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops the background pruning and closes the database
+func (s *storageBBolt) Close() error {
+	s.storePruner.Stop()
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("closing database: %w", err)
+	}
+
+	return nil
+}
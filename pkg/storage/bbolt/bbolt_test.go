@@ -0,0 +1,60 @@
+package bbolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		t.Setenv("BBOLT_PATH", filepath.Join(t.TempDir(), "ots.db"))
+
+		s, err := New()
+		require.NoError(t, err)
+
+		return s
+	})
+}
+
+func TestUpdateKeepDoesNotWrite(t *testing.T) {
+	t.Setenv("BBOLT_PATH", filepath.Join(t.TempDir(), "ots.db"))
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+	db := s.(*storageBBolt).db
+	diskWrites := func() int64 {
+		stats := db.Stats().TxStats
+		return stats.GetWrite()
+	}
+
+	id, err := s.Create(context.Background(), storage.Secret{Secret: "secret", RemainingViews: 2}, 0)
+	require.NoError(t, err)
+
+	writes := diskWrites()
+
+	var calls int
+	secret, err := s.Update(context.Background(), id, func(*storage.Secret) (storage.UpdateAction, error) {
+		calls++
+		return storage.UpdateActionKeep, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret.Secret)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, writes, diskWrites(), "keeping the secret must not write")
+
+	secret, err = storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, secret.RemainingViews)
+	assert.Greater(t, diskWrites(), writes, "consuming a view must write")
+}
+
+// Prune implements the storagetest.Pruner interface
+func (s *storageBBolt) Prune() { s.pruneStore() }
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Closer is implemented by storages holding resources like
+	// connections or background workers which need to be released
+	// before the process exits
+	Closer interface {
+		// Close stops the background workers and releases the resources,
+		// the storage must not be used afterwards
+		Close() error
+	}
+
+	// Periodic runs a function in the background in a fixed interval
+	// until it is stopped
+	Periodic struct {
+		done    chan struct{}
+		stopped chan struct{}
+		once    sync.Once
+	}
+)
+
+// Close releases the resources of the storage if it implements the
+// Closer interface
+func Close(s Storage) error {
+	c, ok := s.(Closer)
+	if !ok {
+		return nil
+	}
+
+	return c.Close() //nolint:wrapcheck // Storage errors are passed through
+}
+
+// RunPeriodic starts calling fn every interval
+func RunPeriodic(interval time.Duration, fn func()) *Periodic {
+	p := &Periodic{
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.stopped)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+
+	return p
+}
+
+// Stop ends the periodic calls and waits for a running call to finish,
+// it is safe to call Stop multiple times
+func (p *Periodic) Stop() {
+	p.once.Do(func() { close(p.done) })
+	<-p.stopped
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+type (
+	// ExpiryNotifier is implemented by storages able to report secrets
+	// having a CallbackURL whose content expired without being read.
+	// Only the ContentExpiry is reported: The storage must still hold
+	// the secret at that time so its Expiry needs to be later.
+	ExpiryNotifier interface {
+		// NotifyExpired registers the function to be called for every
+		// expired secret. Every secret is reported once even when
+		// multiple instances share the same storage.
+		NotifyExpired(fn ExpiredFunc)
+	}
+
+	// ExpiredFunc receives the ID of the expired secret and the secret
+	// itself as it was before its CallbackURL was removed
+	ExpiredFunc func(id string, secret Secret)
+
+	// ExpiryNotifications can be embedded into a storage to implement
+	// the ExpiryNotifier. The storage needs to call NotifyIfExpired for
+	// every secret it considers to be expired.
+	ExpiryNotifications struct {
+		fn atomic.Pointer[ExpiredFunc]
+	}
+)
+
+// NotifyExpired implements the ExpiryNotifier interface
+func (e *ExpiryNotifications) NotifyExpired(fn ExpiredFunc) { e.fn.Store(&fn) }
+
+// NotificationsEnabled tells whether a function has been registered
+// so the storage can skip looking for expired secrets
+func (e *ExpiryNotifications) NotificationsEnabled() bool { return e.fn.Load() != nil }
+
+// NotifyIfExpired claims the notification for the secret by removing
+// its CallbackURL and calls the registered function. If the secret
+// has not expired, has been read or was already claimed nothing
+// happens.
+func (e *ExpiryNotifications) NotifyIfExpired(ctx context.Context, s Storage, id string) error {
+	fn := e.fn.Load()
+	if fn == nil {
+		return nil
+	}
+
+	var expired Secret
+	if _, err := s.Update(ctx, id, func(secret *Secret) (UpdateAction, error) {
+		if !secret.ExpiredUnread() || secret.CallbackURL == "" {
+			expired = Secret{}
+			return UpdateActionKeep, nil
+		}
+
+		expired = *secret
+		secret.CallbackURL = ""
+		return UpdateActionStore, nil
+	}); err != nil {
+		if errors.Is(err, ErrSecretNotFound) {
+			// Removed in the meantime, nothing to report
+			return nil
+		}
+		return err //nolint:wrapcheck // Storage errors are passed through
+	}
+
+	if expired.CallbackURL != "" {
+		(*fn)(id, expired)
+	}
+
+	return nil
+}
+
+// ExpiredUnread tells whether the content of the secret expired before
+// it has been read
+func (s Secret) ExpiredUnread() bool {
+	return !s.IsTombstone() && s.ContentExpired()
+}
@@ -0,0 +1,337 @@
+// Package file implements a storage for secrets on the local filesystem
+// which is suitable for single-node deployments without Redis
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+const (
+	dirMode  = 0o700
+	fileMode = 0o600
+
+	claimSuffix  = ".claimed"
+	secretSuffix = ".secret"
+	tmpPrefix    = ".tmp-"
+
+	// Temp- and claim-files older than this are left-overs from a crash
+	// and will be removed by the pruner
+	staleFileAge = time.Hour
+)
+
+type (
+	storageFile struct {
+		storage.ExpiryNotifications
+
+		dir         string
+		storePruner *storage.Periodic
+
+		// updateLock serializes the read-modify-write cycles within this
+		// process, deletions are additionally protected by the claim
+		updateLock sync.Mutex
+	}
+)
+
+// New creates a new filesystem backed storage in the directory given
+// through the FILE_DIR environment variable
+func New() (storage.Storage, error) {
+	dir := os.Getenv("FILE_DIR")
+	if dir == "" {
+		return nil, fmt.Errorf("FILE_DIR environment variable not set")
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("creating storage directory: %w", err)
+	}
+
+	// Directory might have existed before with more open permissions
+	if err := os.Chmod(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("restricting storage directory permissions: %w", err)
+	}
+
+	s := &storageFile{
+		dir: dir,
+	}
+
+	// Clean up whatever was left from the last run before accepting
+	// new secrets
+	s.pruneStore()
+	s.storePruner = storage.RunPeriodic(time.Minute, s.pruneStore)
+
+	return s, nil
+}
+
+func (s *storageFile) Count(context.Context) (int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("listing storage directory: %w", err)
+	}
+
+	var n int64
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), secretSuffix) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *storageFile) Create(_ context.Context, secret storage.Secret, expireIn time.Duration) (string, error) {
+	id := uuid.Must(uuid.NewV4()).String()
+
+	secret.Expiry = time.Time{}
+	if expireIn > 0 {
+		secret.Expiry = time.Now().Add(expireIn)
+	}
+
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return "", fmt.Errorf("encoding secret: %w", err)
+	}
+
+	if err = s.writeAtomic(s.secretPath(id), data); err != nil {
+		return "", fmt.Errorf("writing secret: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *storageFile) Update(_ context.Context, id string, fn storage.UpdateFunc) (storage.Secret, error) {
+	if _, err := uuid.FromString(id); err != nil {
+		// We only ever create UUIDs so everything else can't exist and
+		// must not be used to construct a path
+		return storage.Secret{}, storage.ErrSecretNotFound
+	}
+
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	secret, err := s.read(s.secretPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return storage.Secret{}, storage.ErrSecretNotFound
+		}
+		return storage.Secret{}, err
+	}
+
+	// Still check to see if the secret has expired in order to prevent a
+	// race condition where a secret has expired but the store pruner has
+	// not yet been invoked.
+	if secret.Expired() {
+		return storage.Secret{}, storage.ErrSecretNotFound
+	}
+
+	action, err := fn(&secret)
+	if err != nil {
+		return storage.Secret{}, err
+	}
+
+	switch action {
+	case storage.UpdateActionStore:
+		data, err := json.Marshal(secret)
+		if err != nil {
+			return storage.Secret{}, fmt.Errorf("encoding secret: %w", err)
+		}
+
+		if err = s.writeAtomic(s.secretPath(id), data); err != nil {
+			return storage.Secret{}, fmt.Errorf("writing secret: %w", err)
+		}
+
+	case storage.UpdateActionDelete:
+		// The claim ensures nobody else (i.e. the pruner) did remove the
+		// secret since we've read it
+		if _, err = s.claimAndRead(s.secretPath(id)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return storage.Secret{}, storage.ErrSecretNotFound
+			}
+			return storage.Secret{}, err
+		}
+	}
+
+	return secret, nil
+}
+
+// claimAndRead moves the secret file out of the way, reads and removes
+// it. As the rename is atomic only one caller can ever claim a secret,
+// all others will receive fs.ErrNotExist.
+func (s *storageFile) claimAndRead(secretPath string) (secret storage.Secret, err error) {
+	claimPath := strings.Join([]string{
+		strings.TrimSuffix(secretPath, secretSuffix),
+		uuid.Must(uuid.NewV4()).String(),
+	}, ".") + claimSuffix
+
+	if err = os.Rename(secretPath, claimPath); err != nil {
+		return secret, fmt.Errorf("claiming secret: %w", err)
+	}
+
+	defer func() {
+		if rmErr := os.Remove(claimPath); rmErr != nil {
+			logrus.WithError(rmErr).Error("removing claimed secret file (will be pruned)")
+		}
+	}()
+
+	return s.read(claimPath)
+}
+
+func (*storageFile) read(secretPath string) (secret storage.Secret, err error) {
+	data, err := os.ReadFile(secretPath) //#nosec:G304 // Path is constructed from a validated UUID
+	if err != nil {
+		return secret, fmt.Errorf("reading secret: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &secret); err != nil {
+		return secret, fmt.Errorf("decoding secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (s *storageFile) pruneStore() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		logrus.WithError(err).Error("listing storage directory for pruning")
+		return
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		p := filepath.Join(s.dir, e.Name())
+
+		switch {
+		case strings.HasSuffix(e.Name(), secretSuffix):
+			data, err := os.ReadFile(p) //#nosec:G304 // Path is taken from directory listing
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					logrus.WithError(err).WithField("file", e.Name()).Error("reading secret for pruning")
+				}
+				continue
+			}
+
+			var secret storage.Secret
+			if err = json.Unmarshal(data, &secret); err != nil {
+				logrus.WithError(err).WithField("file", e.Name()).Error("decoding secret for pruning")
+				continue
+			}
+
+			if !secret.Expired() {
+				if secret.CallbackURL != "" && secret.ExpiredUnread() {
+					id := strings.TrimSuffix(e.Name(), secretSuffix)
+					if err = s.NotifyIfExpired(context.Background(), s, id); err != nil {
+						logrus.WithError(err).WithField("file", e.Name()).Error("notifying about expired secret")
+					}
+				}
+				continue
+			}
+
+			// Claim the secret before removing it so we don't interfere
+			// with a reader having claimed it in the meantime
+			if _, err = s.claimAndRead(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logrus.WithError(err).WithField("file", e.Name()).Error("removing expired secret")
+			}
+
+		case strings.HasPrefix(e.Name(), tmpPrefix), strings.HasSuffix(e.Name(), claimSuffix):
+			info, err := e.Info()
+			if err != nil || time.Since(info.ModTime()) < staleFileAge {
+				continue
+			}
+
+			if err = os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logrus.WithError(err).WithField("file", e.Name()).Error("removing stale file")
+			}
+		}
+	}
+}
+
+func (s *storageFile) secretPath(id string) string {
+	return filepath.Join(s.dir, id+secretSuffix)
+}
+
+// Ping checks the storage directory to be accessible
+func (s *storageFile) Ping(context.Context) error {
+	if _, err := os.ReadDir(s.dir); err != nil {
+		return fmt.Errorf("reading storage directory: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops the background pruning
+func (s *storageFile) Close() error {
+	s.storePruner.Stop()
+	return nil
+}
+
+// writeAtomic writes the data into a temporary file, syncs it to disk
+// and moves it into its final place afterwards so a crash will never
+// leave a partially written secret behind
+func (s *storageFile) writeAtomic(target string, data []byte) (err error) {
+	f, err := os.CreateTemp(s.dir, tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = f.Chmod(fileMode); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("setting file mode: %w", err)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("syncing temp file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), target); err != nil {
+		return fmt.Errorf("moving temp file into place: %w", err)
+	}
+
+	return s.syncDir()
+}
+
+// syncDir ensures the directory entry of a renamed file has been
+// persisted to disk
+func (s *storageFile) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return fmt.Errorf("opening storage directory: %w", err)
+	}
+	defer d.Close() //nolint:errcheck // Directory was only opened for sync
+
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("syncing storage directory: %w", err)
+	}
+
+	return nil
+}
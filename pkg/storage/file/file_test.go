@@ -0,0 +1,115 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		t.Setenv("FILE_DIR", t.TempDir())
+
+		s, err := New()
+		require.NoError(t, err)
+
+		return s
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "secrets")
+	require.NoError(t, os.MkdirAll(dir, 0o755)) //#nosec:G301 // Intentionally too open
+	t.Setenv("FILE_DIR", dir)
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	id, err := s.Create(t.Context(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(dirMode), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(dir, id+secretSuffix))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(fileMode), info.Mode().Perm())
+}
+
+func TestInvalidID(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FILE_DIR", dir)
+
+	// Must not be reachable through a constructed path
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "..", "outside"+secretSuffix), []byte(`{"secret":"outside"}`), fileMode))
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	for _, id := range []string{"", "../outside", "foo"} {
+		_, err = s.Update(t.Context(), id, func(*storage.Secret) (storage.UpdateAction, error) {
+			return storage.UpdateActionKeep, nil
+		})
+		assert.ErrorIs(t, err, storage.ErrSecretNotFound, id)
+	}
+}
+
+func TestPruneStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FILE_DIR", dir)
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	id, err := s.Create(t.Context(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "writes must not leave temp files behind")
+
+	old := time.Now().Add(-2 * staleFileAge)
+	files := map[string]bool{
+		tmpPrefix + "stale":         false,
+		tmpPrefix + "fresh":         true,
+		id + ".stale" + claimSuffix: false,
+		id + ".fresh" + claimSuffix: true,
+		"unrelated":                 true,
+	}
+	for name, fresh := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte("{}"), fileMode))
+		if !fresh {
+			require.NoError(t, os.Chtimes(p, old, old))
+		}
+	}
+
+	s.(*storageFile).pruneStore()
+
+	for name, keep := range files {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if keep {
+			assert.NoError(t, err, name)
+		} else {
+			assert.ErrorIs(t, err, os.ErrNotExist, name)
+		}
+	}
+
+	n, err := s.Count(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "claim files must not be counted")
+}
+
+// Prune implements the storagetest.Pruner interface
+func (s *storageFile) Prune() { s.pruneStore() }
@@ -0,0 +1,21 @@
+package storage
+
+import "context"
+
+// HealthChecker is implemented by storages depending on a backend
+// which might become unavailable (i.e. a database server)
+type HealthChecker interface {
+	// Ping checks the backend to be able to handle requests
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the backend of the storage using its HealthChecker
+// implementation. Other storages are checked by counting the secrets.
+func Ping(ctx context.Context, s Storage) error {
+	if h, ok := s.(HealthChecker); ok {
+		return h.Ping(ctx) //nolint:wrapcheck // Storage errors are passed through
+	}
+
+	_, err := s.Count(ctx)
+	return err //nolint:wrapcheck // Storage errors are passed through
+}
@@ -3,105 +3,116 @@
 package memory
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
 
 	"github.com/Luzifer/ots/pkg/storage"
 )
 
 type (
-	memStorageSecret struct {
-		Expiry time.Time
-		Secret string //#nosec:G117 // This application works with secrets
-	}
-
 	storageMem struct {
+		storage.ExpiryNotifications
 		sync.RWMutex
-		store           map[string]memStorageSecret
-		storePruneTimer *time.Ticker
+		store       map[string]storage.Secret
+		storePruner *storage.Periodic
 	}
 )
 
 // New creates a new In-Mem storage
 func New() storage.Storage {
 	store := &storageMem{
-		store:           make(map[string]memStorageSecret),
-		storePruneTimer: time.NewTicker(time.Minute),
+		store: make(map[string]storage.Secret),
 	}
 
-	go store.storePruner()
+	store.storePruner = storage.RunPeriodic(time.Minute, store.pruneStore)
 
 	return store
 }
 
-func (s *storageMem) Count() (int64, error) {
+func (s *storageMem) Count(context.Context) (int64, error) {
 	s.RLock()
 	defer s.RUnlock()
 
 	return int64(len(s.store)), nil
 }
 
-func (s *storageMem) Create(secret string, expireIn time.Duration) (string, error) {
+func (s *storageMem) Create(_ context.Context, secret storage.Secret, expireIn time.Duration) (string, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	var (
-		expire time.Time
-		id     = uuid.Must(uuid.NewV4()).String()
-	)
+	id := uuid.Must(uuid.NewV4()).String()
 
+	secret.Expiry = time.Time{}
 	if expireIn > 0 {
-		expire = time.Now().Add(expireIn)
+		secret.Expiry = time.Now().Add(expireIn)
 	}
 
-	s.store[id] = memStorageSecret{
-		Expiry: expire,
-		Secret: secret,
-	}
+	s.store[id] = secret
 
 	return id, nil
 }
 
-func (s *storageMem) ReadAndDestroy(id string) (string, error) {
+func (s *storageMem) Update(_ context.Context, id string, fn storage.UpdateFunc) (storage.Secret, error) {
 	s.Lock()
 	defer s.Unlock()
 
 	secret, ok := s.store[id]
 	if !ok {
-		return "", storage.ErrSecretNotFound
+		return storage.Secret{}, storage.ErrSecretNotFound
 	}
 
-	defer delete(s.store, id)
-
 	// Still check to see if the secret has expired in order to prevent a
 	// race condition where a secret has expired but the store pruner has
 	// not yet been invoked.
-	if secret.hasExpired() {
-		return "", storage.ErrSecretNotFound
+	if secret.Expired() {
+		delete(s.store, id)
+		return storage.Secret{}, storage.ErrSecretNotFound
 	}
 
-	return secret.Secret, nil
+	action, err := fn(&secret)
+	if err != nil {
+		return storage.Secret{}, err
+	}
+
+	switch action {
+	case storage.UpdateActionStore:
+		s.store[id] = secret
+
+	case storage.UpdateActionDelete:
+		delete(s.store, id)
+	}
+
+	return secret, nil
 }
 
 func (s *storageMem) pruneStore() {
-	s.Lock()
-	defer s.Unlock()
+	var expired []string
 
+	s.Lock()
 	for k, v := range s.store {
-		if v.hasExpired() {
+		switch {
+		case v.Expired():
 			delete(s.store, k)
+
+		case v.CallbackURL != "" && v.ExpiredUnread():
+			expired = append(expired, k)
 		}
 	}
-}
+	s.Unlock()
 
-func (s *storageMem) storePruner() {
-	for range s.storePruneTimer.C {
-		s.pruneStore()
+	for _, id := range expired {
+		if err := s.NotifyIfExpired(context.Background(), s, id); err != nil {
+			logrus.WithError(err).WithField("id", id).Error("notifying about expired secret")
+		}
 	}
 }
 
-func (m *memStorageSecret) hasExpired() bool {
-	return !m.Expiry.IsZero() && m.Expiry.Before(time.Now())
+// Close stops the background pruning, the secrets are lost
+func (s *storageMem) Close() error {
+	s.storePruner.Stop()
+	return nil
 }
@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) storage.Storage { return New() })
+}
+
+// Prune implements the storagetest.Pruner interface
+func (s *storageMem) Prune() { s.pruneStore() }
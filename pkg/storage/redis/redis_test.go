@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+// miniredisStorage lets the time pass in miniredis instead of sleeping
+// as miniredis does not expire keys on its own
+type miniredisStorage struct {
+	*storageRedis
+	mr *miniredis.Miniredis
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		mr := miniredis.RunT(t)
+		t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+		s, err := New()
+		require.NoError(t, err)
+
+		return miniredisStorage{storageRedis: s.(*storageRedis), mr: mr}
+	})
+}
+
+// Prune implements the storagetest.Pruner interface
+func (m miniredisStorage) Prune() { m.notifyExpired(context.Background()) }
+
+func (m miniredisStorage) Wait(d time.Duration) { m.mr.FastForward(d) }
+
+func TestIndexBackfill(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	// Secrets stored before the index was introduced
+	require.NoError(t, mr.Set(redisDefaultPrefix+":"+uuid.Must(uuid.NewV4()).String(), "secret"))
+	require.NoError(t, mr.Set(redisDefaultPrefix+":"+uuid.Must(uuid.NewV4()).String(), "secret"))
+	mr.SetTTL(redisDefaultPrefix+":"+uuid.Must(uuid.NewV4()).String(), time.Minute)
+	require.NoError(t, mr.Set("unrelated:"+uuid.Must(uuid.NewV4()).String(), "other"))
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	n, err := s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// Index must not be rebuilt on next start
+	require.NoError(t, storage.Close(s))
+	require.NoError(t, mr.Set(redisDefaultPrefix+":"+uuid.Must(uuid.NewV4()).String(), "secret"))
+
+	s, err = New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	n, err = s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestIndexReconcilesExpiredKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	_, err = s.Create(context.Background(), storage.Secret{Secret: "secret"}, time.Second)
+	require.NoError(t, err)
+	_, err = s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	n, err := s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// The index uses the real clock, the key expiry the miniredis one
+	time.Sleep(1100 * time.Millisecond)
+	mr.FastForward(1100 * time.Millisecond)
+
+	n, err = s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestLegacyStringSecret(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close(s) })
+
+	// Secrets stored before multi-view support are plain strings
+	id := uuid.Must(uuid.NewV4()).String()
+	require.NoError(t, mr.Set(redisDefaultPrefix+":"+id, "secret"))
+	mr.SetTTL(redisDefaultPrefix+":"+id, time.Minute)
+
+	secret, err := s.Update(context.Background(), id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.RemainingViews = 2
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret.Secret)
+	assert.InDelta(t, time.Minute, mr.TTL(redisDefaultPrefix+":"+id), float64(time.Second), "conversion must keep the expiry")
+
+	for range 2 {
+		secret, err = storage.ReadAndDestroy(context.Background(), s, id)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", secret.Secret)
+	}
+
+	assert.False(t, mr.Exists(redisDefaultPrefix+":"+id))
+}
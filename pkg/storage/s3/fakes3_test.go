@@ -0,0 +1,265 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeBucket = "ots"
+
+type (
+	// fakeS3 is a minimal in-memory S3 implementation supporting the
+	// operations and conditional writes used by the storage
+	fakeS3 struct {
+		lock    sync.Mutex
+		objects map[string]*fakeObject
+		etags   int
+
+		// beforeConditionalPut is called with the lock held before the
+		// If-Match header of a write is checked in order to simulate a
+		// concurrent modification
+		beforeConditionalPut func(key string, meta http.Header)
+	}
+
+	fakeObject struct {
+		body     []byte
+		etag     string
+		meta     http.Header
+		modified time.Time
+	}
+
+	fakeListResult struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []fakeListEntry
+	}
+
+	fakeListEntry struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+)
+
+// newFakeS3 starts the fake and configures the storage to use it
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+
+	f := &fakeS3{objects: map[string]*fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("S3_ENDPOINT", srv.URL)
+	t.Setenv("S3_BUCKET", fakeBucket)
+	t.Setenv("S3_REGION", "us-east-1")
+	t.Setenv("S3_ACCESS_KEY_ID", "access")
+	t.Setenv("S3_SECRET_ACCESS_KEY", "secret")
+
+	return f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != fakeBucket {
+		fakeError(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r)
+
+	case r.Method == http.MethodPut:
+		f.put(w, r, key)
+
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		f.get(w, r, key)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		fakeError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// bump replaces the object by a new version as a concurrent writer
+// would do, the lock must be held
+func (f *fakeS3) bump(key string, meta map[string]string) {
+	obj := f.objects[key]
+	for k, v := range meta {
+		obj.meta.Set("X-Amz-Meta-"+k, v)
+	}
+	obj.etag = f.nextETag()
+	obj.modified = time.Now()
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	obj, ok := f.objects[key]
+	switch {
+	case !ok:
+		fakeError(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+
+	case r.Header.Get("If-Match") != "" && strings.Trim(r.Header.Get("If-Match"), `"`) != obj.etag:
+		// The client fetches the content pinned to the version it got
+		// the info for
+		fakeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	for k, v := range obj.meta {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.body)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	res := fakeListResult{
+		Name:    fakeBucket,
+		Prefix:  r.URL.Query().Get("prefix"),
+		MaxKeys: 1000, //nolint:mnd // S3 default
+	}
+
+	for key, obj := range f.objects {
+		if !strings.HasPrefix(key, res.Prefix) {
+			continue
+		}
+		res.Contents = append(res.Contents, fakeListEntry{
+			Key:          key,
+			LastModified: obj.modified.UTC().Format(time.RFC3339),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         len(obj.body),
+		})
+	}
+	sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+	res.KeyCount = len(res.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeS3) nextETag() string {
+	f.etags++
+	return fmt.Sprintf("%032x", f.etags)
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := readFakeBody(r)
+	if err != nil {
+		fakeError(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	meta := http.Header{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = v
+		}
+	}
+
+	existing, exists := f.objects[key]
+
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		fakeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if f.beforeConditionalPut != nil && exists {
+			f.beforeConditionalPut(key, meta)
+			existing = f.objects[key]
+		}
+
+		switch {
+		case !exists:
+			fakeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		case strings.Trim(match, `"`) != existing.etag:
+			fakeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+	}
+
+	obj := &fakeObject{body: body, etag: f.nextETag(), meta: meta, modified: time.Now()}
+	f.objects[key] = obj
+
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// readFakeBody reads the request body and decodes the aws-chunked
+// encoding used by the client for unencrypted connections
+func readFakeBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body) //nolint:wrapcheck // Test helper
+	}
+
+	var (
+		body bytes.Buffer
+		rd   = bufio.NewReader(r.Body)
+	)
+
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading chunk header: %w", err)
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing chunk size: %w", err)
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+
+		if _, err = io.CopyN(&body, rd, size); err != nil {
+			return nil, fmt.Errorf("reading chunk: %w", err)
+		}
+		if _, err = rd.Discard(2); err != nil { //nolint:mnd // Trailing CRLF
+			return nil, fmt.Errorf("reading chunk end: %w", err)
+		}
+	}
+}
+
+func fakeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
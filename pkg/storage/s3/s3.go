@@ -0,0 +1,488 @@
+// Package s3 implements a storage for secrets on any S3-compatible
+// object storage (AWS S3, MinIO, ...)
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+const (
+	s3DefaultPrefix = "io.luzifer.ots/"
+
+	// User-Metadata keys as returned by the client (canonicalized
+	// header names without the X-Amz-Meta- prefix)
+	metaCallbackURL       = "Ots-Callback-Url"
+	metaConsumed          = "Ots-Consumed"
+	metaContentExpires    = "Ots-Content-Expires"
+	metaDeletionTokenHash = "Ots-Deletion-Token-Hash"
+	metaExpires           = "Ots-Expires"
+	metaPINAttempts       = "Ots-Pin-Attempts"
+	metaPINHash           = "Ots-Pin-Hash"
+	metaReadAt            = "Ots-Read-At"
+	metaRemainingViews    = "Ots-Remaining-Views"
+	metaStatusTokenHash   = "Ots-Status-Token-Hash"
+
+	// Optimistic updates are retried when the secret was modified
+	// concurrently, every round at least one of the updates succeeds
+	maxUpdateAttempts = 100
+
+	// Consumed objects older than this are left-overs from a crash
+	// between claiming and deleting and will be removed by the pruner
+	staleClaimAge = time.Hour
+)
+
+var (
+	errPreconditionFailed = errors.New("precondition failed")
+	errTooManyConflicts   = errors.New("too many concurrent modifications")
+)
+
+type storageS3 struct {
+	*storage.ExpiryNotifications
+
+	bucket      string
+	conn        *minio.Client
+	prefix      string
+	storePruner *storage.Periodic
+}
+
+// New returns a new S3 backed storage configured through the S3_*
+// environment variables
+func New() (storage.Storage, error) {
+	if os.Getenv("S3_ENDPOINT") == "" || os.Getenv("S3_BUCKET") == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET environment variables must be set")
+	}
+
+	endpoint, err := url.Parse(os.Getenv("S3_ENDPOINT"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("parsing S3_ENDPOINT: must be an URL like https://s3.amazonaws.com")
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvMinio{},
+		&credentials.EnvAWS{},
+		&credentials.IAM{},
+	})
+	if os.Getenv("S3_ACCESS_KEY_ID") != "" {
+		creds = credentials.NewStaticV4(os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"), "")
+	}
+
+	conn, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  creds,
+		Region: os.Getenv("S3_REGION"),
+		Secure: endpoint.Scheme == "https",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating S3 client: %w", err)
+	}
+
+	s := &storageS3{
+		ExpiryNotifications: new(storage.ExpiryNotifications),
+
+		bucket: os.Getenv("S3_BUCKET"),
+		conn:   conn,
+		prefix: s3DefaultPrefix,
+	}
+
+	if prfx, ok := os.LookupEnv("S3_PREFIX"); ok {
+		s.prefix = prfx
+	}
+
+	exists, err := conn.BucketExists(context.Background(), s.bucket)
+	if err != nil {
+		return nil, fmt.Errorf("checking bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %q does not exist", s.bucket)
+	}
+
+	s.storePruner = storage.RunPeriodic(time.Minute, s.pruneStore)
+
+	return s, nil
+}
+
+func (s storageS3) Count(ctx context.Context) (n int64, err error) {
+	for obj := range s.conn.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return n, fmt.Errorf("listing objects: %w", obj.Err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func (s storageS3) Create(ctx context.Context, secret storage.Secret, expireIn time.Duration) (string, error) {
+	id := uuid.Must(uuid.NewV4()).String()
+
+	secret.Expiry = time.Time{}
+	if expireIn > 0 {
+		secret.Expiry = time.Now().Add(expireIn)
+	}
+
+	opts := minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: secretMeta(secret),
+	}
+	// Never overwrite an existing secret, even if we'd have generated
+	// a duplicate UUID
+	opts.SetMatchETagExcept("*")
+
+	if _, err := s.conn.PutObject(
+		ctx, s.bucket, s.objectKey(id),
+		strings.NewReader(secret.Secret), int64(len(secret.Secret)), opts,
+	); err != nil {
+		return "", fmt.Errorf("writing object: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s storageS3) Update(ctx context.Context, id string, fn storage.UpdateFunc) (storage.Secret, error) {
+	for range maxUpdateAttempts {
+		secret, info, err := s.read(ctx, id)
+		switch {
+		case errors.Is(err, errPreconditionFailed):
+			continue
+
+		case err != nil:
+			return storage.Secret{}, err
+		}
+
+		// Still check to see if the secret has expired in order to prevent a
+		// race condition where a secret has expired but the store pruner has
+		// not yet been invoked.
+		if hasExpired(info.UserMetadata) {
+			return storage.Secret{}, storage.ErrSecretNotFound
+		}
+
+		action, err := fn(&secret)
+		if err != nil {
+			return storage.Secret{}, err
+		}
+
+		// All writes are conditional on the ETag we've read so of
+		// concurrent updates only one can succeed, all others will
+		// receive a failed precondition and start over
+		switch action {
+		case storage.UpdateActionStore:
+			err = s.store(ctx, id, secret, info)
+
+		case storage.UpdateActionDelete:
+			err = s.claim(ctx, id, info.ETag)
+		}
+
+		switch {
+		case errors.Is(err, errPreconditionFailed):
+			continue
+
+		case err != nil:
+			return storage.Secret{}, err
+		}
+
+		if action == storage.UpdateActionDelete {
+			if err = s.conn.RemoveObject(ctx, s.bucket, s.objectKey(id), minio.RemoveObjectOptions{}); err != nil {
+				// The secret has been claimed by us and cannot be read again,
+				// the pruner will take care of the object
+				logrus.WithError(err).Error("removing claimed secret object (will be pruned)")
+			}
+		}
+
+		return secret, nil
+	}
+
+	return storage.Secret{}, fmt.Errorf("updating secret: %w", errTooManyConflicts)
+}
+
+// claim overwrites the object with an empty consumed marker if and
+// only if it still has the ETag we've read. Of concurrent readers
+// only one can match the ETag, all others will receive a failed
+// precondition.
+func (s storageS3) claim(ctx context.Context, id, etag string) error {
+	opts := minio.PutObjectOptions{
+		UserMetadata: map[string]string{metaConsumed: strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	opts.SetMatchETag(etag)
+
+	if _, err := s.conn.PutObject(ctx, s.bucket, s.objectKey(id), bytes.NewReader(nil), 0, opts); err != nil {
+		return s.writeError(err, "claiming object")
+	}
+
+	return nil
+}
+
+func (s storageS3) objectKey(id string) string {
+	return s.prefix + id
+}
+
+// read fetches the secret and the object info. Objects already
+// claimed by another reader are reported as not found. The content is
+// fetched separately from the info and only if the object has not been
+// modified in the meantime, otherwise errPreconditionFailed is
+// returned.
+func (s storageS3) read(ctx context.Context, id string) (storage.Secret, minio.ObjectInfo, error) {
+	obj, err := s.conn.GetObject(ctx, s.bucket, s.objectKey(id), minio.GetObjectOptions{})
+	if err != nil {
+		return storage.Secret{}, minio.ObjectInfo{}, fmt.Errorf("getting object: %w", err)
+	}
+	defer obj.Close() //nolint:errcheck // Object is only read
+
+	info, err := obj.Stat()
+	if err != nil {
+		if isNotFound(err) {
+			return storage.Secret{}, info, storage.ErrSecretNotFound
+		}
+		return storage.Secret{}, info, fmt.Errorf("getting object info: %w", err)
+	}
+
+	if metaValue(info.UserMetadata, metaConsumed) != "" {
+		// Someone else has already claimed the secret and is about to
+		// delete it
+		return storage.Secret{}, info, storage.ErrSecretNotFound
+	}
+
+	content, err := io.ReadAll(obj)
+	if err != nil {
+		return storage.Secret{}, info, s.writeError(err, "reading object")
+	}
+
+	secret, err := secretFromMeta(info.UserMetadata)
+	if err != nil {
+		return storage.Secret{}, info, err
+	}
+	secret.Secret = string(content)
+
+	return secret, info, nil
+}
+
+// store replaces the object with the modified secret if and only if
+// it still has the ETag we've read
+func (s storageS3) store(ctx context.Context, id string, secret storage.Secret, info minio.ObjectInfo) error {
+	opts := minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: secretMeta(secret),
+	}
+	opts.SetMatchETag(info.ETag)
+
+	if _, err := s.conn.PutObject(
+		ctx, s.bucket, s.objectKey(id),
+		strings.NewReader(secret.Secret), int64(len(secret.Secret)), opts,
+	); err != nil {
+		return s.writeError(err, "writing object")
+	}
+
+	return nil
+}
+
+// writeError translates the errors of conditional writes and reads
+func (storageS3) writeError(err error, desc string) error {
+	switch {
+	case isNotFound(err):
+		return storage.ErrSecretNotFound
+
+	case minio.ToErrorResponse(err).Code == minio.PreconditionFailed:
+		return errPreconditionFailed
+
+	default:
+		return fmt.Errorf("%s: %w", desc, err)
+	}
+}
+
+func (s storageS3) pruneStore() {
+	ctx := context.Background()
+
+	for obj := range s.conn.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:       s.prefix,
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			logrus.WithError(obj.Err).Error("listing objects for pruning")
+			return
+		}
+
+		meta := obj.UserMetadata
+		if meta == nil {
+			// Not all implementations return the metadata when listing
+			info, err := s.conn.StatObject(ctx, s.bucket, obj.Key, minio.StatObjectOptions{})
+			if err != nil {
+				if !isNotFound(err) {
+					logrus.WithError(err).WithField("key", obj.Key).Error("getting object info for pruning")
+				}
+				continue
+			}
+			meta = info.UserMetadata
+		}
+
+		if !hasExpired(meta) && metaValue(meta, metaConsumed) == "" {
+			s.notifyIfExpired(ctx, strings.TrimPrefix(obj.Key, s.prefix), meta)
+		}
+
+		if !hasExpired(meta) && (metaValue(meta, metaConsumed) == "" || time.Since(obj.LastModified) < staleClaimAge) {
+			continue
+		}
+
+		if err := s.conn.RemoveObject(ctx, s.bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			logrus.WithError(err).WithField("key", obj.Key).Error("removing expired secret object")
+		}
+	}
+}
+
+// notifyIfExpired reports the secret if its content expired unread
+// while still having a callback to report to
+func (s storageS3) notifyIfExpired(ctx context.Context, id string, meta map[string]string) {
+	secret, err := secretFromMeta(meta)
+	if err != nil || secret.CallbackURL == "" || !secret.ExpiredUnread() {
+		return
+	}
+
+	if err = s.NotifyIfExpired(ctx, s, id); err != nil {
+		logrus.WithError(err).WithField("id", id).Error("notifying about expired secret")
+	}
+}
+
+// Ping checks the bucket to be reachable
+func (s storageS3) Ping(ctx context.Context) error {
+	exists, err := s.conn.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("checking bucket: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %q does not exist", s.bucket)
+	}
+
+	return nil
+}
+
+// Close stops the background pruning
+func (s storageS3) Close() error {
+	s.storePruner.Stop()
+	return nil
+}
+
+// secretMeta returns the user-metadata to store the secret attributes
+// in, the content itself is stored as object body
+func secretMeta(secret storage.Secret) map[string]string {
+	meta := map[string]string{}
+	if secret.CallbackURL != "" {
+		meta[metaCallbackURL] = secret.CallbackURL
+	}
+	for key, t := range map[string]time.Time{
+		metaContentExpires: secret.ContentExpiry,
+		metaExpires:        secret.Expiry,
+		metaReadAt:         secret.ReadAt,
+	} {
+		if !t.IsZero() {
+			meta[key] = strconv.FormatInt(t.Unix(), 10)
+		}
+	}
+	if secret.DeletionTokenHash != "" {
+		meta[metaDeletionTokenHash] = secret.DeletionTokenHash
+	}
+	if secret.PINAttempts > 0 {
+		meta[metaPINAttempts] = strconv.Itoa(secret.PINAttempts)
+	}
+	if secret.PINHash != "" {
+		meta[metaPINHash] = secret.PINHash
+	}
+	if secret.RemainingViews > 0 {
+		meta[metaRemainingViews] = strconv.Itoa(secret.RemainingViews)
+	}
+	if secret.StatusTokenHash != "" {
+		meta[metaStatusTokenHash] = secret.StatusTokenHash
+	}
+
+	return meta
+}
+
+// secretFromMeta parses the secret attributes from the user-metadata
+func secretFromMeta(meta map[string]string) (secret storage.Secret, err error) {
+	secret.CallbackURL = metaValue(meta, metaCallbackURL)
+	secret.DeletionTokenHash = metaValue(meta, metaDeletionTokenHash)
+	secret.PINHash = metaValue(meta, metaPINHash)
+	secret.StatusTokenHash = metaValue(meta, metaStatusTokenHash)
+
+	if v := metaValue(meta, metaRemainingViews); v != "" {
+		if secret.RemainingViews, err = strconv.Atoi(v); err != nil {
+			return secret, fmt.Errorf("parsing remaining views: %w", err)
+		}
+	}
+
+	if v := metaValue(meta, metaPINAttempts); v != "" {
+		if secret.PINAttempts, err = strconv.Atoi(v); err != nil {
+			return secret, fmt.Errorf("parsing pin attempts: %w", err)
+		}
+	}
+
+	for key, t := range map[string]*time.Time{
+		metaContentExpires: &secret.ContentExpiry,
+		metaExpires:        &secret.Expiry,
+		metaReadAt:         &secret.ReadAt,
+	} {
+		v := metaValue(meta, key)
+		if v == "" {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return secret, fmt.Errorf("parsing %s: %w", key, err)
+		}
+		*t = time.Unix(ts, 0)
+	}
+
+	return secret, nil
+}
+
+func hasExpired(meta map[string]string) bool {
+	v := metaValue(meta, metaExpires)
+	if v == "" {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		// Invalid expiry, treat it as expired as we cannot tell
+		return true
+	}
+
+	return exp <= time.Now().Unix()
+}
+
+// metaValue reads the user-metadata regardless whether it was returned
+// by a stat (key without prefix) or a listing (full header name)
+func metaValue(meta map[string]string, key string) string {
+	if v, ok := meta[key]; ok {
+		return v
+	}
+
+	return meta["X-Amz-Meta-"+key]
+}
+
+func isNotFound(err error) bool {
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.StatusCode == http.StatusNotFound
+	}
+
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	// Runs against a MinIO instance when the S3_* variables are set,
+	// against the in-memory fake otherwise
+	realS3 := os.Getenv("S3_ENDPOINT") != ""
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		if !realS3 {
+			newFakeS3(t)
+		}
+
+		// Every test gets its own prefix in order not to see the
+		// objects of the other tests
+		t.Setenv("S3_PREFIX", "ots-test/"+uuid.Must(uuid.NewV4()).String()+"/")
+
+		s, err := New()
+		require.NoError(t, err)
+
+		return s
+	})
+}
+
+func TestUpdateRetriesOnConflict(t *testing.T) {
+	f := newFakeS3(t)
+	s := newTestStorage(t)
+
+	id, err := s.Create(context.Background(), storage.Secret{Secret: "secret", RemainingViews: 3}, 0)
+	require.NoError(t, err)
+
+	// Another instance reads the secret between our read and write
+	f.beforeConditionalPut = func(key string, _ http.Header) {
+		f.beforeConditionalPut = nil
+		f.bump(key, map[string]string{metaRemainingViews: "2"})
+	}
+
+	var calls int
+	secret, err := s.Update(context.Background(), id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		calls++
+		secret.RemainingViews--
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls, "update must be retried with the current state")
+	assert.Equal(t, 1, secret.RemainingViews)
+
+	secret, err = storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err)
+	assert.Equal(t, 0, secret.RemainingViews)
+}
+
+func TestClaimConflict(t *testing.T) {
+	f := newFakeS3(t)
+	s := newTestStorage(t)
+
+	id, err := s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	// Another reader claims the secret between our read and claim
+	f.beforeConditionalPut = func(key string, meta http.Header) {
+		require.NotEmpty(t, meta.Get("X-Amz-Meta-"+metaConsumed), "delete must claim the object")
+		f.bump(key, map[string]string{metaConsumed: "1"})
+	}
+
+	var calls int
+	_, err = s.Update(context.Background(), id, func(*storage.Secret) (storage.UpdateAction, error) {
+		calls++
+		return storage.UpdateActionDelete, nil
+	})
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "secret must only be handed out once")
+	assert.Equal(t, 1, calls, "claimed secret must not be passed to the update again")
+}
+
+func TestTooManyConflicts(t *testing.T) {
+	f := newFakeS3(t)
+	s := newTestStorage(t)
+
+	id, err := s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	f.beforeConditionalPut = func(key string, _ http.Header) { f.bump(key, nil) }
+
+	var calls int
+	_, err = s.Update(context.Background(), id, func(*storage.Secret) (storage.UpdateAction, error) {
+		calls++
+		return storage.UpdateActionStore, nil
+	})
+	assert.ErrorIs(t, err, errTooManyConflicts)
+	assert.Equal(t, maxUpdateAttempts, calls)
+}
+
+func TestPruneConsumed(t *testing.T) {
+	f := newFakeS3(t)
+	s := newTestStorage(t)
+
+	stale, err := s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+	fresh, err := s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	// Instance crashed between claiming and removing the objects
+	f.lock.Lock()
+	for _, id := range []string{stale, fresh} {
+		f.bump(s.objectKey(id), map[string]string{metaConsumed: "1"})
+	}
+	f.objects[s.objectKey(stale)].modified = time.Now().Add(-2 * staleClaimAge)
+	f.lock.Unlock()
+
+	s.pruneStore()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	assert.NotContains(t, f.objects, s.objectKey(stale), "stale claim must be removed")
+	assert.Contains(t, f.objects, s.objectKey(fresh), "claim might still be in use")
+}
+
+func newTestStorage(t *testing.T) *storageS3 {
+	t.Helper()
+
+	s, err := New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.(storage.Closer).Close() })
+
+	return s.(*storageS3)
+}
+
+// Prune implements the storagetest.Pruner interface
+func (s storageS3) Prune() { s.pruneStore() }
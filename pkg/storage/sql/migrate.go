@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations contains the schema changes for each dialect. Entries
+// must never be changed or removed once released, only new entries
+// may be appended as the index is used as schema version.
+var migrations = map[string][]string{
+	dialectPostgres: {
+		`CREATE TABLE ots_secrets (
+			id TEXT NOT NULL PRIMARY KEY,
+			secret TEXT NOT NULL,
+			expires_at BIGINT NULL
+		)`,
+		`CREATE INDEX ots_secrets_expires_at_idx ON ots_secrets (expires_at)`,
+		`ALTER TABLE ots_secrets ADD COLUMN remaining_views INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE ots_secrets ADD COLUMN deletion_token_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN status_token_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN content_expires_at BIGINT NULL`,
+		`ALTER TABLE ots_secrets ADD COLUMN read_at BIGINT NULL`,
+		`ALTER TABLE ots_secrets ADD COLUMN callback_url TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN pin_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN pin_attempts INTEGER NOT NULL DEFAULT 0`,
+	},
+
+	dialectSQLite: {
+		`CREATE TABLE ots_secrets (
+			id TEXT NOT NULL PRIMARY KEY,
+			secret TEXT NOT NULL,
+			expires_at INTEGER NULL
+		)`,
+		`CREATE INDEX ots_secrets_expires_at_idx ON ots_secrets (expires_at)`,
+		`ALTER TABLE ots_secrets ADD COLUMN remaining_views INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE ots_secrets ADD COLUMN deletion_token_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN status_token_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN content_expires_at INTEGER NULL`,
+		`ALTER TABLE ots_secrets ADD COLUMN read_at INTEGER NULL`,
+		`ALTER TABLE ots_secrets ADD COLUMN callback_url TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN pin_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE ots_secrets ADD COLUMN pin_attempts INTEGER NOT NULL DEFAULT 0`,
+	},
+}
+
+// migrate brings the database schema up to date by executing all
+// migrations not yet recorded in the ots_schema_version table
+func (s *storageSQL) migrate() (err error) {
+	if _, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS ots_schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("creating schema version table: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Is a no-op after commit
+
+	if s.dialect == dialectPostgres {
+		// Prevent multiple instances starting at the same time from
+		// applying the same migrations concurrently
+		if _, err = tx.Exec(`LOCK TABLE ots_schema_version IN EXCLUSIVE MODE`); err != nil {
+			return fmt.Errorf("locking schema version table: %w", err)
+		}
+	}
+
+	var version sql.NullInt64
+	if err = tx.QueryRow(`SELECT MAX(version) FROM ots_schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	for i, stmt := range migrations[s.dialect] {
+		if int64(i) < version.Int64 {
+			continue
+		}
+
+		if _, err = tx.Exec(stmt); err != nil {
+			return fmt.Errorf("executing migration %d: %w", i+1, err)
+		}
+	}
+
+	if n := int64(len(migrations[s.dialect])); n > version.Int64 {
+		if _, err = tx.Exec(`DELETE FROM ots_schema_version`); err != nil {
+			return fmt.Errorf("clearing schema version: %w", err)
+		}
+
+		if _, err = tx.Exec(`INSERT INTO ots_schema_version (version) VALUES ($1)`, n); err != nil {
+			return fmt.Errorf("storing schema version: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing migrations: %w", err)
+	}
+
+	return nil
+}
@@ -3,13 +3,74 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
 type (
+	// Secret contains the data stored for a single secret
+	Secret struct {
+		// Secret contains the (usually client-side encrypted) content
+		Secret string `json:"secret"` //#nosec:G117 // This application works with secrets
+		// RemainingViews is the number of reads left before the secret
+		// is destroyed. Zero is treated the same as one view.
+		RemainingViews int `json:"remaining_views,omitempty"`
+		// DeletionTokenHash contains the hash of the token the creator
+		// can use to destroy the secret before it has been read
+		DeletionTokenHash string `json:"deletion_token_hash,omitempty"`
+		// StatusTokenHash contains the hash of the token the creator can
+		// use to query whether the secret has been read
+		StatusTokenHash string `json:"status_token_hash,omitempty"`
+		// CallbackURL receives the events of the secret, it is removed
+		// once the expiry of the content has been reported
+		CallbackURL string `json:"callback_url,omitempty"`
+		// PINHash contains the slow hash of the PIN required to read
+		// the secret, PINAttempts counts the wrong PINs given
+		PINHash     string `json:"pin_hash,omitempty"`
+		PINAttempts int    `json:"pin_attempts,omitempty"`
+
+		// Expiry is the point in time the storage removes the secret,
+		// the zero value keeps it forever. It is set by Create from the
+		// expiry given and may be changed by an UpdateFunc.
+		Expiry time.Time `json:"expiry"`
+		// ContentExpiry is the point in time the content must no longer
+		// be handed out while the secret itself is kept until its Expiry
+		// to be able to report its status
+		ContentExpiry time.Time `json:"content_expiry,omitzero"`
+		// ReadAt is set when the last view of the secret was consumed
+		// and its content was removed, leaving a tombstone behind
+		ReadAt time.Time `json:"read_at,omitzero"`
+	}
+
 	// Storage is the interface to implement in each storage provider
 	Storage interface {
+		// Count returns the number of stored secrets
+		Count(ctx context.Context) (int64, error)
+		// Create inserts a new secret and returns its ID
+		Create(ctx context.Context, secret Secret, expireIn time.Duration) (string, error)
+		// Update atomically modifies the secret with the given ID using
+		// the UpdateFunc and returns the secret as left by it. Secrets
+		// which do not exist or have expired yield ErrSecretNotFound.
+		Update(ctx context.Context, id string, fn UpdateFunc) (Secret, error)
+	}
+
+	// UpdateAction tells the storage what to do with the secret after
+	// the UpdateFunc has been executed
+	UpdateAction int
+
+	// UpdateFunc receives the current state of the secret and decides
+	// what to do with it. A storage may call it multiple times when it
+	// encounters concurrent modifications, therefore it must not have
+	// any side-effects. Returning an error aborts the update and leaves
+	// the secret untouched.
+	UpdateFunc func(secret *Secret) (UpdateAction, error)
+
+	// LegacyStorage is the interface storage providers had to implement
+	// before the context was passed down. It can be converted into a
+	// Storage using FromLegacy.
+	LegacyStorage interface {
 		// Count returns the number of stored secrets
 		Count() (int64, error)
 		// Create inserts a new secret and returns its ID
@@ -18,8 +79,196 @@ type (
 		// from the storage
 		ReadAndDestroy(id string) (string, error)
 	}
+
+	legacyAdapter struct {
+		s LegacyStorage
+	}
+
+	// consumer is implemented by storages not able to do an Update
+	// but to consume a secret in one step
+	consumer interface {
+		consume(ctx context.Context, id string, check func(*Secret) error) (Secret, error)
+	}
+)
+
+const (
+	// UpdateActionKeep leaves the secret unchanged, modifications
+	// done by the UpdateFunc are discarded
+	UpdateActionKeep UpdateAction = iota
+	// UpdateActionStore persists the modified secret
+	UpdateActionStore
+	// UpdateActionDelete removes the secret from the storage
+	UpdateActionDelete
 )
 
 // ErrSecretNotFound is a generic error to be returned when a secret
 // does not exist in the backend. It will then be handled by API.
 var ErrSecretNotFound = errors.New("secret not found")
+
+// ErrNotSupported is returned by storages not able to fulfill the
+// requested operation (i.e. legacy storages asked to keep a secret)
+var ErrNotSupported = errors.New("operation not supported by storage")
+
+// ReadAndDestroy consumes one view of the secret and removes it from
+// the storage when no views are left. The returned secret contains
+// the number of remaining views after this read.
+func ReadAndDestroy(ctx context.Context, s Storage, id string) (Secret, error) {
+	return ReadAndKeepTombstone(ctx, s, id, 0)
+}
+
+// ReadAndKeepTombstone works like ReadAndDestroy but instead of
+// removing a secret having a status token after its last view its
+// content is removed and the tombstone is kept for the given retention
+// in order to report the status. With a zero retention no tombstone
+// is kept.
+func ReadAndKeepTombstone(ctx context.Context, s Storage, id string, retention time.Duration) (Secret, error) {
+	return ReadAndKeepTombstoneChecked(ctx, s, id, retention, nil)
+}
+
+// ReadAndKeepTombstoneChecked works like ReadAndKeepTombstone but
+// passes the secret to the check before consuming the view. The read
+// is refused when the check returns an error, changes made by the
+// check are stored together with the consumed view.
+func ReadAndKeepTombstoneChecked(ctx context.Context, s Storage, id string, retention time.Duration, check func(*Secret) error) (Secret, error) {
+	if c, ok := s.(consumer); ok {
+		return c.consume(ctx, id, check) //nolint:wrapcheck // Storage errors are passed through
+	}
+
+	var content string
+
+	secret, err := s.Update(ctx, id, func(secret *Secret) (UpdateAction, error) {
+		if secret.IsTombstone() || secret.ContentExpired() {
+			return UpdateActionKeep, ErrSecretNotFound
+		}
+
+		if check != nil {
+			if err := check(secret); err != nil {
+				return UpdateActionKeep, err
+			}
+		}
+
+		content = secret.Secret
+
+		if secret.RemainingViews > 1 {
+			secret.RemainingViews--
+			return UpdateActionStore, nil
+		}
+
+		secret.RemainingViews = 0
+
+		if retention <= 0 || secret.StatusTokenHash == "" {
+			return UpdateActionDelete, nil
+		}
+
+		secret.Expiry = time.Now().Add(retention)
+		secret.ReadAt = time.Now()
+		secret.Secret = ""
+		return UpdateActionStore, nil
+	})
+	if err != nil {
+		return secret, err //nolint:wrapcheck // Storage errors are passed through
+	}
+
+	// The stored tombstone does not have the content anymore
+	secret.Secret = content
+	return secret, nil
+}
+
+// ContentExpired tells whether the content of the secret must no
+// longer be handed out
+func (s Secret) ContentExpired() bool {
+	return !s.ContentExpiry.IsZero() && s.ContentExpiry.Before(time.Now())
+}
+
+// Expired tells whether the secret has reached its Expiry and must
+// be treated as removed from the storage
+func (s Secret) Expired() bool {
+	return !s.Expiry.IsZero() && s.Expiry.Before(time.Now())
+}
+
+// IsTombstone tells whether the secret has been read and only its
+// status is kept
+func (s Secret) IsTombstone() bool {
+	return !s.ReadAt.IsZero()
+}
+
+// FromLegacy wraps a storage provider not yet supporting contexts.
+// As the legacy provider cannot be interrupted the context is only
+// checked before the operation is started: A cancelled context will
+// prevent the operation but an operation already running will not
+// be aborted, especially a read will never lose a secret because of
+// a deadline hit while reading it.
+//
+// Legacy providers are only able to store the secret content and to
+// destroy it on read: Secrets with more than one view cannot be
+// created, all other attributes of the secret are discarded (the
+// ContentExpiry is used as expiry of the secret). As they cannot read a
+// secret without consuming it, Update is not supported and secrets can
+// only be read through ReadAndDestroy and its variants.
+func FromLegacy(s LegacyStorage) Storage { return legacyAdapter{s} }
+
+func (l legacyAdapter) Count(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("checking context: %w", err)
+	}
+
+	return l.s.Count() //nolint:wrapcheck // Adapter should not alter the errors
+}
+
+func (l legacyAdapter) Create(ctx context.Context, secret Secret, expireIn time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("checking context: %w", err)
+	}
+
+	if secret.RemainingViews > 1 {
+		return "", fmt.Errorf("storing multi-view secret: %w", ErrNotSupported)
+	}
+
+	if !secret.ContentExpiry.IsZero() {
+		// Without the ability to keep a tombstone the secret can be
+		// removed as soon as its content must not be read anymore
+		expireIn = max(time.Until(secret.ContentExpiry), time.Second)
+	}
+
+	return l.s.Create(secret.Secret, expireIn) //nolint:wrapcheck // Adapter should not alter the errors
+}
+
+// Update is refused as the legacy provider cannot read a secret
+// without destroying it: Reading a secret only to keep it would make
+// it vanish. Secrets are consumed through ReadAndDestroy instead.
+func (l legacyAdapter) Update(ctx context.Context, _ string, _ UpdateFunc) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return Secret{}, fmt.Errorf("checking context: %w", err)
+	}
+
+	return Secret{}, fmt.Errorf("updating secret: %w", ErrNotSupported)
+}
+
+func (l legacyAdapter) consume(ctx context.Context, id string, check func(*Secret) error) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return Secret{}, fmt.Errorf("checking context: %w", err)
+	}
+
+	content, err := l.s.ReadAndDestroy(id)
+	if err != nil {
+		return Secret{}, err //nolint:wrapcheck // Adapter should not alter the errors
+	}
+
+	secret := Secret{Secret: content}
+	if check != nil {
+		if err = check(&secret); err != nil {
+			// The secret is already gone so we need to tell about that
+			return Secret{}, errors.Join(err, fmt.Errorf("restoring secret: %w", ErrNotSupported))
+		}
+	}
+
+	return secret, nil
+}
+
+func (l legacyAdapter) Close() error {
+	if c, ok := l.s.(Closer); ok {
+		return c.Close() //nolint:wrapcheck // Adapter should not alter the errors
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/storagetest"
+)
+
+// legacyStorage is a minimal storage still implementing the interface
+// without context to test the adapter against
+type legacyStorage struct {
+	sync.Mutex
+	secrets map[string]legacySecret
+}
+
+type legacySecret struct {
+	expiry time.Time
+	secret string
+}
+
+func TestLegacyAdapter(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) storage.Storage {
+		return storage.FromLegacy(&legacyStorage{secrets: map[string]legacySecret{}})
+	})
+}
+
+func TestLegacyAdapterKeep(t *testing.T) {
+	s := storage.FromLegacy(&legacyStorage{secrets: map[string]legacySecret{}})
+
+	id, err := s.Create(context.Background(), storage.Secret{Secret: "secret"}, 0)
+	require.NoError(t, err)
+
+	_, err = s.Update(context.Background(), id, func(*storage.Secret) (storage.UpdateAction, error) {
+		t.Error("legacy storage must not read the secret for an update")
+		return storage.UpdateActionKeep, nil
+	})
+	assert.ErrorIs(t, err, storage.ErrNotSupported)
+
+	secret, err := storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err, "secret must survive the refused update")
+	assert.Equal(t, "secret", secret.Secret)
+
+	_, err = storage.ReadAndDestroy(context.Background(), s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func TestPeriodic(t *testing.T) {
+	var calls atomic.Int64
+
+	p := storage.RunPeriodic(time.Millisecond, func() { calls.Add(1) })
+	assert.Eventually(t, func() bool { return calls.Load() > 1 }, time.Second, time.Millisecond)
+
+	p.Stop()
+	p.Stop()
+
+	stopped := calls.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, calls.Load(), "function must not be called after Stop")
+}
+
+func (l *legacyStorage) Count() (int64, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	return int64(len(l.secrets)), nil
+}
+
+func (l *legacyStorage) Create(secret string, expireIn time.Duration) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	id := uuid.Must(uuid.NewV4()).String()
+	s := legacySecret{secret: secret}
+	if expireIn > 0 {
+		s.expiry = time.Now().Add(expireIn)
+	}
+	l.secrets[id] = s
+
+	return id, nil
+}
+
+func (l *legacyStorage) ReadAndDestroy(id string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	s, ok := l.secrets[id]
+	if !ok {
+		return "", storage.ErrSecretNotFound
+	}
+	delete(l.secrets, id)
+
+	if !s.expiry.IsZero() && s.expiry.Before(time.Now()) {
+		return "", storage.ErrSecretNotFound
+	}
+
+	return s.secret, nil
+}
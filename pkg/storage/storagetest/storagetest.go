@@ -0,0 +1,675 @@
+// Package storagetest contains a conformance test suite to be run
+// against storage.Storage implementations in order to ensure they
+// behave like the storages shipped with OTS
+//
+// To use it add a test to your storage package:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			s, err := New(...)
+//			require.NoError(t, err)
+//			return s
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+const (
+	concurrentReaders = 25
+	countSecrets      = 5
+	multiViews        = 3
+	testSecret        = "I'm a very secret secret"
+
+	// Some backends store the expiry with a precision of one second,
+	// therefore all expiry related tests need to respect that
+	expiryPrecision = time.Second
+)
+
+type (
+	// Factory creates a fresh and empty storage for a single test. The
+	// factory is called once for each test with the sub-test so it may
+	// use t.Setenv or t.TempDir to configure the storage.
+	Factory func(t *testing.T) storage.Storage
+
+	// Waiter can be implemented by the storage returned from the
+	// Factory in case the backend does not use the real clock for its
+	// expiry (for example an in-memory Redis stand-in). If it is not
+	// implemented the tests will sleep.
+	Waiter interface {
+		Wait(d time.Duration)
+	}
+
+	// Pruner can be implemented by the storage returned from the
+	// Factory to synchronously run the periodic search for expired
+	// secrets. If it is not implemented the expiry notifications of
+	// storage.ExpiryNotifier implementations are not tested.
+	Pruner interface {
+		Prune()
+	}
+)
+
+// Run executes all conformance tests against storages created through
+// the given Factory, the storages are closed after their test
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+
+	tests := map[string]func(*testing.T, storage.Storage){
+		"CreateAndRead":             testCreateAndRead,
+		"ReadNonExisting":           testReadNonExisting,
+		"ReadOnlyOnce":              testReadOnlyOnce,
+		"ConcurrentReadAndDestroy":  testConcurrentReadAndDestroy,
+		"ExpiryBoundary":            testExpiryBoundary,
+		"ZeroExpiry":                testZeroExpiry,
+		"CountAccuracy":             testCountAccuracy,
+		"IDsAreUnique":              testIDsAreUnique,
+		"CancelledContextIsHonored": testCancelledContext,
+		"MultiView":                 testMultiView,
+		"ConcurrentMultiView":       testConcurrentMultiView,
+		"UpdateKeep":                testUpdateKeep,
+		"UpdateStore":               testUpdateStore,
+		"UpdateError":               testUpdateError,
+		"AttributesArePersisted":    testAttributesPersisted,
+		"ContentExpiry":             testContentExpiry,
+		"ExpiryIsReported":          testExpiryIsReported,
+		"ExpiryNotification":        testExpiryNotification,
+		"UpdateExpiry":              testUpdateExpiry,
+		"Tombstone":                 testTombstone,
+		"Close":                     testClose,
+		"Ping":                      testPing,
+	}
+
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := factory(t)
+			// Stops the background routines of the storage, closing it a
+			// second time after the Close test must not matter
+			t.Cleanup(func() { _ = storage.Close(s) })
+
+			fn(t, s)
+		})
+	}
+}
+
+func testClose(t *testing.T, s storage.Storage) {
+	_, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, 0)
+	require.NoError(t, err)
+
+	assert.NoError(t, storage.Close(s))
+}
+
+func testPing(t *testing.T, s storage.Storage) {
+	assert.NoError(t, storage.Ping(context.Background(), s))
+}
+
+func testAttributesPersisted(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	// Every attribute of the secret needs to have a non-zero value in
+	// here to ensure it is stored by the backend
+	want := storage.Secret{
+		Secret:            testSecret,
+		RemainingViews:    multiViews,
+		DeletionTokenHash: "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8",
+		StatusTokenHash:   "b109f3bbbc244eb82441917ed06d618b9008dd09b3befd1b5e07394c706a8bb9",
+		CallbackURL:       "https://example.com/hooks/ots",
+		PINHash:           "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		PINAttempts:       2,
+		Expiry:            time.Now().Add(time.Minute),
+		ContentExpiry:     time.Now().Add(time.Minute / 2),
+		ReadAt:            time.Now(),
+	}
+
+	id, err := s.Create(ctx, want, time.Minute)
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	keep := func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil }
+
+	got, err := s.Update(ctx, id, keep)
+	require.NoError(t, err)
+	assertSecretEqual(t, want, got, "attributes must be stored on create")
+
+	want.Secret = "modified"
+	want.RemainingViews = 1
+	want.DeletionTokenHash = ""
+	want.StatusTokenHash = ""
+	want.CallbackURL = ""
+	want.PINHash = ""
+	want.PINAttempts = 0
+	want.Expiry = time.Now().Add(2 * time.Minute)
+	want.ContentExpiry = time.Time{}
+	want.ReadAt = time.Time{}
+
+	_, err = s.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		*secret = want
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+
+	got, err = s.Update(ctx, id, keep)
+	require.NoError(t, err)
+	assertSecretEqual(t, want, got, "attributes must be stored on update")
+}
+
+func testCancelledContext(t *testing.T, s storage.Storage) {
+	id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The backend is free to either ignore the context (local storages)
+	// or to fail the operation but it must not hand out the secret
+	// while failing or lose it while succeeding
+	secret, err := storage.ReadAndDestroy(ctx, s, id)
+	if err == nil {
+		assert.Equal(t, testSecret, secret.Secret)
+		return
+	}
+
+	secret, err = storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err, "secret must still be readable after cancelled read")
+	assert.Equal(t, testSecret, secret.Secret)
+}
+
+func testConcurrentReadAndDestroy(t *testing.T, s storage.Storage) {
+	id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	var (
+		mu        sync.Mutex
+		successes int
+		start     = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+
+	for range concurrentReaders {
+		wg.Go(func() {
+			<-start
+
+			secret, err := storage.ReadAndDestroy(context.Background(), s, id)
+			if err != nil {
+				assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+				return
+			}
+
+			assert.Equal(t, testSecret, secret.Secret)
+
+			mu.Lock()
+			defer mu.Unlock()
+			successes++
+		})
+	}
+
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, 1, successes, "secret must be handed out exactly once")
+}
+
+func testConcurrentMultiView(t *testing.T, s storage.Storage) {
+	id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret, RemainingViews: multiViews}, time.Minute)
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	var (
+		mu        sync.Mutex
+		remaining = map[int]bool{}
+		start     = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+
+	for range concurrentReaders {
+		wg.Go(func() {
+			<-start
+
+			secret, err := storage.ReadAndDestroy(context.Background(), s, id)
+			if err != nil {
+				assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+				return
+			}
+
+			assert.Equal(t, testSecret, secret.Secret)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.False(t, remaining[secret.RemainingViews], "view must not be handed out twice")
+			remaining[secret.RemainingViews] = true
+		})
+	}
+
+	close(start)
+	wg.Wait()
+
+	assert.Len(t, remaining, multiViews, "secret must be handed out exactly once per view")
+}
+
+func testCountAccuracy(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	n, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "fresh storage must be empty")
+
+	ids := make([]string, 0, countSecrets)
+	for range countSecrets {
+		id, err := s.Create(ctx, storage.Secret{Secret: testSecret}, time.Minute)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	n, err = s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(countSecrets), n)
+
+	for i, id := range ids {
+		_, err = storage.ReadAndDestroy(ctx, s, id)
+		require.NoError(t, err)
+
+		n, err = s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(countSecrets-i-1), n)
+	}
+
+	// Failed reads must not alter the count
+	_, err = storage.ReadAndDestroy(ctx, s, ids[0])
+	require.ErrorIs(t, err, storage.ErrSecretNotFound)
+
+	n, err = s.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func testCreateAndRead(t *testing.T, s storage.Storage) {
+	id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	secret, err := storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err)
+	assert.Equal(t, testSecret, secret.Secret)
+}
+
+func testContentExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{
+		Secret:        testSecret,
+		ContentExpiry: time.Now().Add(-expiryPrecision),
+	}, time.Minute)
+	require.NoError(t, err)
+
+	secret, err := s.Update(ctx, id, func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil })
+	skipUnsupported(t, err)
+	require.NoError(t, err, "secret must be kept after its content expired")
+	assert.True(t, secret.ContentExpired())
+
+	_, err = storage.ReadAndDestroy(ctx, s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "content must not be readable after its expiry")
+}
+
+func testExpiryBoundary(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	// Two secrets, one being read right before its expiry, one right
+	// after its expiry
+	before, err := s.Create(ctx, storage.Secret{Secret: testSecret}, 3*expiryPrecision)
+	require.NoError(t, err)
+
+	after, err := s.Create(ctx, storage.Secret{Secret: testSecret}, expiryPrecision)
+	require.NoError(t, err)
+
+	wait(s, expiryPrecision+expiryPrecision/2)
+
+	secret, err := storage.ReadAndDestroy(ctx, s, before)
+	require.NoError(t, err, "secret must be readable before its expiry")
+	assert.Equal(t, testSecret, secret.Secret)
+
+	_, err = storage.ReadAndDestroy(ctx, s, after)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "secret must not be readable after its expiry")
+}
+
+func testExpiryIsReported(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret, Expiry: time.Now().Add(time.Hour)}, time.Minute)
+	require.NoError(t, err)
+
+	secret, err := s.Update(ctx, id, func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil })
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), secret.Expiry, expiryPrecision, "expiry must be taken from Create")
+
+	id, err = s.Create(ctx, storage.Secret{Secret: testSecret}, 0)
+	require.NoError(t, err)
+
+	secret, err = storage.ReadAndDestroy(ctx, s, id)
+	require.NoError(t, err)
+	assert.True(t, secret.Expiry.IsZero(), "secret without expiry must have zero expiry")
+}
+
+func testExpiryNotification(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	notifier, ok := s.(storage.ExpiryNotifier)
+	if !ok {
+		t.Skip("storage does not report expired secrets")
+	}
+	pruner, ok := s.(Pruner)
+	if !ok {
+		t.Skip("storage does not support synchronous pruning")
+	}
+
+	var (
+		expired  = make(map[string]storage.Secret)
+		expiredL sync.Mutex
+	)
+	notifier.NotifyExpired(func(id string, secret storage.Secret) {
+		expiredL.Lock()
+		defer expiredL.Unlock()
+		expired[id] = secret
+	})
+
+	const callbackURL = "https://example.com/hooks/ots"
+
+	want, err := s.Create(ctx, storage.Secret{
+		Secret:        testSecret,
+		CallbackURL:   callbackURL,
+		ContentExpiry: time.Now().Add(-expiryPrecision),
+	}, time.Minute)
+	require.NoError(t, err)
+
+	for name, secret := range map[string]storage.Secret{
+		"pending": {Secret: testSecret, CallbackURL: callbackURL, ContentExpiry: time.Now().Add(time.Minute)},
+		"read":    {CallbackURL: callbackURL, ContentExpiry: time.Now().Add(-expiryPrecision), ReadAt: time.Now()},
+		"silent":  {Secret: testSecret, ContentExpiry: time.Now().Add(-expiryPrecision)},
+	} {
+		_, err = s.Create(ctx, secret, time.Minute)
+		require.NoError(t, err, name)
+	}
+
+	// Pruning twice must not report the secret twice
+	pruner.Prune()
+	pruner.Prune()
+
+	expiredL.Lock()
+	defer expiredL.Unlock()
+
+	require.Len(t, expired, 1, "only the unread secret with callback must be reported")
+	require.Contains(t, expired, want)
+	assert.Equal(t, callbackURL, expired[want].CallbackURL, "reported secret must contain the callback")
+
+	secret, err := s.Update(ctx, want, func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil })
+	require.NoError(t, err, "secret must be kept after being reported")
+	assert.Empty(t, secret.CallbackURL, "callback must be removed after being reported")
+}
+
+func testIDsAreUnique(t *testing.T, s storage.Storage) {
+	seen := map[string]bool{}
+
+	for range countSecrets {
+		id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, time.Minute)
+		require.NoError(t, err)
+		assert.False(t, seen[id], "ID must not be handed out twice")
+		seen[id] = true
+	}
+}
+
+func testMultiView(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret, RemainingViews: multiViews}, time.Minute)
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	for i := multiViews - 1; i >= 0; i-- {
+		secret, err := storage.ReadAndDestroy(ctx, s, id)
+		require.NoError(t, err)
+		assert.Equal(t, testSecret, secret.Secret)
+		assert.Equal(t, i, secret.RemainingViews)
+
+		// The secret stays stored until its last view
+		n, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(min(i, 1)), n)
+	}
+
+	_, err = storage.ReadAndDestroy(ctx, s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func testReadNonExisting(t *testing.T, s storage.Storage) {
+	for _, id := range []string{
+		"00000000-0000-0000-0000-000000000000",
+		"c2f0c9b2-3c58-4d3b-8f0e-5b4e3a0d3e1f",
+		"not-a-uuid",
+		"../../../etc/passwd",
+	} {
+		_, err := storage.ReadAndDestroy(context.Background(), s, id)
+		assert.ErrorIs(t, err, storage.ErrSecretNotFound, "id %q", id)
+	}
+}
+
+func testReadOnlyOnce(t *testing.T, s storage.Storage) {
+	id, err := s.Create(context.Background(), storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = storage.ReadAndDestroy(context.Background(), s, id)
+	require.NoError(t, err)
+
+	_, err = storage.ReadAndDestroy(context.Background(), s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func testTombstone(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	keep := func(*storage.Secret) (storage.UpdateAction, error) { return storage.UpdateActionKeep, nil }
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret, StatusTokenHash: "hash"}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, id, keep)
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	secret, err := storage.ReadAndKeepTombstone(ctx, s, id, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, testSecret, secret.Secret, "content must be returned on last read")
+
+	tombstone, err := s.Update(ctx, id, keep)
+	require.NoError(t, err, "tombstone must be kept")
+	assert.True(t, tombstone.IsTombstone())
+	assert.Empty(t, tombstone.Secret, "tombstone must not contain the content")
+	assert.WithinDuration(t, time.Now(), tombstone.ReadAt, expiryPrecision)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), tombstone.Expiry, expiryPrecision)
+
+	_, err = storage.ReadAndKeepTombstone(ctx, s, id, time.Hour)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "tombstone must not be readable")
+
+	// Without status token there is nobody to report the status to
+	id, err = s.Create(ctx, storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = storage.ReadAndKeepTombstone(ctx, s, id, time.Hour)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, id, keep)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func testUpdateError(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	errTest := errors.New("test error")
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.Secret = "modified"
+		return storage.UpdateActionDelete, errTest
+	})
+	skipUnsupported(t, err)
+	require.ErrorIs(t, err, errTest)
+
+	secret, err := storage.ReadAndDestroy(ctx, s, id)
+	require.NoError(t, err, "failed update must not alter the secret")
+	assert.Equal(t, testSecret, secret.Secret)
+}
+
+func testUpdateKeep(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	secret, err := s.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.RemainingViews = multiViews
+		return storage.UpdateActionKeep, nil
+	})
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+	assert.Equal(t, testSecret, secret.Secret)
+
+	secret, err = storage.ReadAndDestroy(ctx, s, id)
+	require.NoError(t, err)
+	assert.Equal(t, testSecret, secret.Secret)
+
+	_, err = storage.ReadAndDestroy(ctx, s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "kept modifications must not be stored")
+}
+
+func testUpdateStore(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret}, 3*expiryPrecision)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.RemainingViews = 2
+		return storage.UpdateActionStore, nil
+	})
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	for range 2 {
+		secret, err := storage.ReadAndDestroy(ctx, s, id)
+		require.NoError(t, err)
+		assert.Equal(t, testSecret, secret.Secret)
+	}
+
+	// Storing must keep the expiry of the secret
+	id, err = s.Create(ctx, storage.Secret{Secret: testSecret}, expiryPrecision)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, id, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.RemainingViews = 2
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+
+	wait(s, expiryPrecision+expiryPrecision/2)
+
+	_, err = storage.ReadAndDestroy(ctx, s, id)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func testUpdateExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	extended, err := s.Create(ctx, storage.Secret{Secret: testSecret}, expiryPrecision)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, extended, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.Expiry = time.Now().Add(time.Minute)
+		return storage.UpdateActionStore, nil
+	})
+	skipUnsupported(t, err)
+	require.NoError(t, err)
+
+	shortened, err := s.Create(ctx, storage.Secret{Secret: testSecret}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.Update(ctx, shortened, func(secret *storage.Secret) (storage.UpdateAction, error) {
+		secret.Expiry = time.Now().Add(expiryPrecision)
+		return storage.UpdateActionStore, nil
+	})
+	require.NoError(t, err)
+
+	wait(s, expiryPrecision+expiryPrecision/2)
+
+	_, err = storage.ReadAndDestroy(ctx, s, extended)
+	require.NoError(t, err, "secret must be readable within its extended expiry")
+
+	_, err = storage.ReadAndDestroy(ctx, s, shortened)
+	assert.ErrorIs(t, err, storage.ErrSecretNotFound, "secret must not be readable after its shortened expiry")
+}
+
+func testZeroExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Create(ctx, storage.Secret{Secret: testSecret}, 0)
+	require.NoError(t, err)
+
+	// A secret without expiry must survive the time any expiring
+	// secret would have been removed in
+	wait(s, expiryPrecision+expiryPrecision/2)
+
+	n, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	secret, err := storage.ReadAndDestroy(ctx, s, id)
+	require.NoError(t, err)
+	assert.Equal(t, testSecret, secret.Secret)
+}
+
+// assertSecretEqual compares the secrets allowing the times to differ
+// by the expiry precision of the backends
+func assertSecretEqual(t *testing.T, want, got storage.Secret, msg string) {
+	t.Helper()
+
+	for _, ts := range [][2]*time.Time{
+		{&want.Expiry, &got.Expiry},
+		{&want.ContentExpiry, &got.ContentExpiry},
+		{&want.ReadAt, &got.ReadAt},
+	} {
+		assert.Equal(t, ts[0].IsZero(), ts[1].IsZero(), msg)
+		if !ts[0].IsZero() {
+			assert.WithinDuration(t, *ts[0], *ts[1], expiryPrecision, msg)
+		}
+		*ts[0], *ts[1] = time.Time{}, time.Time{}
+	}
+
+	assert.Equal(t, want, got, msg)
+}
+
+// skipUnsupported skips the test in case the storage tells it does
+// not support the feature under test
+func skipUnsupported(t *testing.T, err error) {
+	t.Helper()
+
+	if errors.Is(err, storage.ErrNotSupported) {
+		t.Skipf("not supported by storage: %s", err)
+	}
+}
+
+func wait(s storage.Storage, d time.Duration) {
+	if w, ok := s.(Waiter); ok {
+		w.Wait(d)
+		return
+	}
+
+	time.Sleep(d)
+}
@@ -0,0 +1,293 @@
+// Package webhook delivers signed events about secrets to the
+// callback URLs given when creating them
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventSecretRead is sent whenever the secret has been read
+	EventSecretRead = "secret.read"
+	// EventSecretExpired is sent when the secret expired before all
+	// of its views have been used
+	EventSecretExpired = "secret.expired"
+
+	// HeaderSignature contains the signature of the request created
+	// by Sign
+	HeaderSignature = "X-OTS-Signature"
+	// HeaderTimestamp contains the unix timestamp the request was
+	// signed at
+	HeaderTimestamp = "X-OTS-Timestamp"
+
+	signaturePrefix = "sha256="
+
+	deliveryTimeout = 10 * time.Second
+	maxAttempts     = 5
+	maxResponseSize = 64 * 1024
+	maxRetryDelay   = time.Minute
+	queueSize       = 100
+	retryBaseDelay  = time.Second
+	workers         = 4
+)
+
+type (
+	// Dispatcher signs events and delivers them in the background,
+	// failed deliveries are retried with an exponential backoff
+	Dispatcher struct {
+		baseDelay time.Duration
+		client    *http.Client
+		key       []byte
+		queue     chan delivery
+
+		closed  bool
+		lock    sync.Mutex
+		retries map[*time.Timer]delivery
+		workers sync.WaitGroup
+	}
+
+	// Event is sent as JSON body to the callback URL
+	Event struct {
+		// ID is unique for every event and stays the same for all
+		// delivery attempts so receivers can drop duplicates
+		ID       string    `json:"id"`
+		Event    string    `json:"event"`
+		SecretID string    `json:"secret_id"`
+		Time     time.Time `json:"time"`
+
+		// RemainingViews is set for EventSecretRead only
+		RemainingViews *int `json:"remaining_views,omitempty"`
+	}
+
+	delivery struct {
+		attempt int
+		body    []byte
+		url     string
+	}
+)
+
+var errPermanent = errors.New("permanent delivery failure")
+
+// New creates a Dispatcher signing the events with the given key and
+// starts its workers
+func New(key string) *Dispatcher {
+	d := &Dispatcher{
+		baseDelay: retryBaseDelay,
+		client: &http.Client{
+			// Callback URLs are checked against an allowlist, following
+			// redirects would leave it
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Timeout:       deliveryTimeout,
+		},
+		key:     []byte(key),
+		queue:   make(chan delivery, queueSize),
+		retries: make(map[*time.Timer]delivery),
+	}
+
+	d.workers.Add(workers)
+	for range workers {
+		go d.worker()
+	}
+
+	return d
+}
+
+// Sign creates the signature sent in the HeaderSignature for the given
+// timestamp (as sent in HeaderTimestamp) and request body
+func Sign(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature against the timestamp and request body
+// in constant time. Receivers should additionally reject timestamps
+// too far in the past to prevent replays.
+func Verify(key []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(key, timestamp, body)), []byte(signature))
+}
+
+// Close stops accepting events and delivers the queued ones: Retries
+// waiting for their backoff are attempted immediately, deliveries
+// failing from now on are not retried anymore. Events not delivered
+// when the context is done are dropped.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.lock.Lock()
+	if !d.closed {
+		d.closed = true
+		for t, dl := range d.retries {
+			t.Stop()
+			d.push(dl)
+		}
+		clear(d.retries)
+		close(d.queue)
+	}
+	d.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("dropping %d queued events: %w", len(d.queue), ctx.Err())
+	}
+}
+
+// Send queues the event for delivery to the callback URL. ID and Time
+// are filled if not set. In case the queue is full the event is
+// dropped.
+func (d *Dispatcher) Send(callbackURL string, ev Event) {
+	if ev.ID == "" {
+		ev.ID = uuid.Must(uuid.NewV4()).String()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		logrus.WithError(err).Error("encoding webhook event")
+		return
+	}
+
+	d.enqueue(delivery{body: body, url: callbackURL})
+}
+
+func (d *Dispatcher) deliver(dl delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.url, bytes.NewReader(dl.body))
+	if err != nil {
+		return fmt.Errorf("%w: creating request: %w", errPermanent, err)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(d.key, ts, dl.body))
+
+	resp, err := d.client.Do(req) //#nosec:G704 // Callback URLs are checked against the allowlist
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logrus.WithError(err).Error("closing webhook response body (leaked fd)")
+		}
+	}()
+
+	// Drain the body to allow reusing the connection
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return nil
+
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+
+	default:
+		return fmt.Errorf("%w: unexpected status %d", errPermanent, resp.StatusCode)
+	}
+}
+
+func (d *Dispatcher) enqueue(dl delivery) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		logrus.WithField("url", dl.url).Error("webhook dispatcher closed, dropping event")
+		return
+	}
+
+	d.push(dl)
+}
+
+// push adds the delivery to the queue, the lock must be held
+func (d *Dispatcher) push(dl delivery) {
+	select {
+	case d.queue <- dl:
+	default:
+		logrus.WithField("url", dl.url).Error("webhook queue full, dropping event")
+	}
+}
+
+// retry enqueues the delivery again after its backoff, pending retries
+// are taken over by Close
+func (d *Dispatcher) retry(dl delivery) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		logrus.WithField("url", dl.url).Error("webhook dispatcher closed, giving up")
+		return
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(d.retryDelay(dl.attempt), func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		if _, ok := d.retries[t]; !ok {
+			// Already taken over by Close
+			return
+		}
+		delete(d.retries, t)
+		d.push(dl)
+	})
+	d.retries[t] = dl
+}
+
+// retryDelay returns the delay before the given attempt
+func (d *Dispatcher) retryDelay(attempt int) time.Duration {
+	delay := d.baseDelay << (attempt - 1) //#nosec:G115 // attempt is limited by maxAttempts
+	return min(delay, maxRetryDelay)
+}
+
+func (d *Dispatcher) worker() {
+	defer d.workers.Done()
+
+	for dl := range d.queue {
+		dl.attempt++
+
+		err := d.deliver(dl)
+		if err == nil {
+			continue
+		}
+
+		logger := logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": dl.attempt,
+			"url":     dl.url,
+		})
+
+		if errors.Is(err, errPermanent) || dl.attempt >= maxAttempts {
+			logger.Error("delivering webhook failed, giving up")
+			continue
+		}
+
+		logger.Warn("delivering webhook failed, retrying")
+		d.retry(dl)
+	}
+}
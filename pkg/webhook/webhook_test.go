@@ -0,0 +1,175 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "webhook-secret"
+
+func TestDeliverySigned(t *testing.T) {
+	events := make(chan Event, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if !Verify([]byte(testKey), r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var ev Event
+		require.NoError(t, json.Unmarshal(body, &ev))
+		events <- ev
+	}))
+	t.Cleanup(srv.Close)
+
+	views := 2
+	New(testKey).Send(srv.URL, Event{Event: EventSecretRead, SecretID: "foo", RemainingViews: &views})
+
+	select {
+	case ev := <-events:
+		assert.NotEmpty(t, ev.ID)
+		assert.Equal(t, EventSecretRead, ev.Event)
+		assert.Equal(t, "foo", ev.SecretID)
+		assert.WithinDuration(t, time.Now(), ev.Time, time.Second)
+		require.NotNil(t, ev.RemainingViews)
+		assert.Equal(t, 2, *ev.RemainingViews)
+
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	var calls atomic.Int32
+	done := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2: //nolint:mnd // Second call
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			close(done)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	d := New(testKey)
+	d.baseDelay = time.Millisecond
+	d.Send(srv.URL, Event{Event: EventSecretExpired, SecretID: "foo"})
+
+	select {
+	case <-done:
+		assert.Equal(t, int32(3), calls.Load())
+	case <-time.After(time.Second):
+		t.Fatal("event was not retried")
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	for name, status := range map[string]int{
+		"client error": http.StatusBadRequest,
+		"redirect":     http.StatusFound,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				http.Redirect(w, r, "/elsewhere", status)
+			}))
+			t.Cleanup(srv.Close)
+
+			d := New(testKey)
+			d.baseDelay = time.Millisecond
+			d.Send(srv.URL, Event{Event: EventSecretExpired, SecretID: "foo"})
+
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, int32(1), calls.Load(), "must neither retry nor follow redirects")
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	var calls, delivered atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		delivered.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+
+	d := New(testKey)
+	d.baseDelay = time.Hour
+	d.Send(srv.URL, Event{Event: EventSecretRead, SecretID: "retried"})
+
+	// Wait for the retry to be scheduled
+	require.Eventually(t, func() bool {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return len(d.retries) == 1
+	}, time.Second, time.Millisecond)
+
+	for range 10 {
+		d.Send(srv.URL, Event{Event: EventSecretRead, SecretID: "queued"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, d.Close(ctx))
+	assert.Equal(t, int32(11), delivered.Load(), "queue and pending retries must be delivered")
+
+	d.Send(srv.URL, Event{Event: EventSecretRead, SecretID: "late"})
+	assert.Equal(t, int32(12), calls.Load(), "events must not be accepted after close")
+}
+
+func TestCloseTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-block }))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(block) })
+
+	d := New(testKey)
+	d.Send(srv.URL, Event{Event: EventSecretRead, SecretID: "foo"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := New(testKey)
+
+	assert.Equal(t, time.Second, d.retryDelay(1))
+	assert.Equal(t, 4*time.Second, d.retryDelay(3))
+	assert.Equal(t, maxRetryDelay, d.retryDelay(10))
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"secret.read"}`)
+	sig := Sign([]byte(testKey), "1700000000", body)
+
+	assert.True(t, Verify([]byte(testKey), "1700000000", body, sig))
+	assert.False(t, Verify([]byte(testKey), "1700000001", body, sig), "timestamp must be signed")
+	assert.False(t, Verify([]byte("other"), "1700000000", body, sig), "key must be checked")
+	assert.False(t, Verify([]byte(testKey), "1700000000", []byte(`{}`), sig), "body must be signed")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type peerAddrContextKey struct{}
+
+// handleTrustedProxies replaces the remote address of requests passed
+// through a trusted proxy by the client address given in the header
+// configured to be set by the proxy. The address of the proxy is kept
+// to be retrieved using peerAddr. Requests received through a unix
+// socket are always passed by a local proxy.
+func handleTrustedProxies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if !localPeer(r) && !addrInSubnetList(r.RemoteAddr, cust.TrustedProxies) {
+			next.ServeHTTP(res, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), peerAddrContextKey{}, r.RemoteAddr)
+		r = r.WithContext(ctx)
+
+		if ip := forwardedClientIP(r); ip != nil {
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+
+		next.ServeHTTP(res, r)
+	})
+}
+
+// forwardedClientIP walks the addresses given by the proxies from the
+// nearest to the farthest and returns the first one not being a
+// trusted proxy. If all addresses are trusted the farthest one is the
+// client. Invalid entries stop the walk as the proxy added them cannot
+// be trusted to have verified the addresses before. Other headers are
+// ignored as the proxy might pass them through from the client.
+func forwardedClientIP(r *http.Request) net.IP {
+	hdr := http.CanonicalHeaderKey(cust.TrustedProxyHeader)
+	if hdr == "" {
+		return nil
+	}
+	hops := parseForwardingHeader(hdr, r.Header.Values(hdr))
+
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseAddrIP(hops[i])
+		if ip == nil {
+			break
+		}
+
+		client = ip
+		if !addrInSubnetList(ip.String(), cust.TrustedProxies) {
+			break
+		}
+	}
+
+	return client
+}
+
+// parseForwardingHeader returns the addresses listed in the header
+// values in the order they were added by the proxies
+func parseForwardingHeader(hdr string, values []string) (hops []string) {
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if hdr != "Forwarded" {
+				hops = append(hops, strings.TrimSpace(element))
+				continue
+			}
+
+			// RFC 7239: for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"
+			var hop string
+			for _, pair := range strings.Split(element, ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// localPeer tells whether the request was received through a unix
+// socket which means the peer is a local process
+func localPeer(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// peerAddr returns the address of the peer the request was received
+// from, which is the proxy for requests passed through a trusted proxy
+func peerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(peerAddrContextKey{}).(string); ok {
+		return addr
+	}
+
+	return r.RemoteAddr
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Luzifer/ots/pkg/customization"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	oldCust := cust
+	t.Cleanup(func() { cust = oldCust })
+
+	var remote, peer string
+	hdl := handleTrustedProxies(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		remote, peer = clientIP(r), peerAddr(r)
+	}))
+
+	for name, tc := range map[string]struct {
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		"untrusted peer": {
+			remote:  "192.0.2.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "192.0.2.1",
+		},
+		"trusted without header": {
+			remote: "10.0.0.1:1234",
+			want:   "10.0.0.1",
+		},
+		"x-forwarded-for": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.1, 10.0.0.2"},
+			want:    "192.0.2.1",
+		},
+		"x-forwarded-for all trusted": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		"x-forwarded-for invalid": {
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, unknown, 10.0.0.2"},
+			want:    "10.0.0.2",
+		},
+		"forwarded": {
+			header:  "Forwarded",
+			remote:  "[fd00::1]:1234",
+			headers: map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::17]:4711";by=10.0.0.1`},
+			want:    "2001:db8::/64",
+		},
+		"forwarded obfuscated": {
+			header:  "Forwarded",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"},
+			want:    "10.0.0.2",
+		},
+		"x-real-ip": {
+			header:  "x-real-ip",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		"client supplied header ignored": {
+			header:  "X-Real-IP",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "192.0.2.1"},
+			want:    "192.0.2.1",
+		},
+		"configured header missing": {
+			header:  "X-Real-IP",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "10.0.0.1",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cust = customization.Customize{
+				TrustedProxies:     []string{"10.0.0.0/8", "fd00::/8"},
+				TrustedProxyHeader: tc.header,
+			}
+			if cust.TrustedProxyHeader == "" {
+				cust.TrustedProxyHeader = "X-Forwarded-For"
+			}
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			hdl.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.want, remote)
+			assert.Equal(t, tc.remote, peer, "peer must be kept")
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/ots/pkg/ratelimit"
+	"github.com/Luzifer/ots/pkg/storage/redis"
+)
+
+type (
+	// limitBackend creates the limiters and failure stores keeping
+	// their state either in memory or in Redis
+	limitBackend struct {
+		conn goredis.UniversalClient
+	}
+
+	// statusRecorder captures the status written by a handler
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+// newLimitBackend creates the backend configured through the
+// rate-limit-storage, defaulting to Redis when storing the secrets
+// there in order to share the state between instances. When using a
+// storage shared between instances the backend must be chosen as
+// counting in memory would multiply the limits by the instances.
+func newLimitBackend() (b limitBackend, err error) {
+	storageType := cfg.RateLimitStorage
+	if storageType == "" {
+		switch {
+		case cfg.StorageType == "redis":
+			storageType = "redis"
+
+		case sharedStorageTypes[cfg.StorageType] && limitsConfigured():
+			return b, fmt.Errorf("rate-limit-storage must be set when using the %s storage (mem to count per instance, redis to share the state)", cfg.StorageType)
+
+		default:
+			storageType = "mem"
+		}
+	}
+
+	switch storageType {
+	case "mem":
+		return b, nil
+
+	case "redis":
+		if b.conn, err = redis.NewClient(); err != nil {
+			return b, fmt.Errorf("creating redis client: %w", err)
+		}
+		return b, nil
+
+	default:
+		return b, fmt.Errorf("rate limit storage %q not found", storageType)
+	}
+}
+
+// Close closes the connection to Redis if there is one
+func (b limitBackend) Close() error {
+	if b.conn == nil {
+		return nil
+	}
+
+	if err := b.conn.Close(); err != nil {
+		return fmt.Errorf("closing redis client: %w", err)
+	}
+
+	return nil
+}
+
+func (b limitBackend) failureStore(name string) ratelimit.FailureStore {
+	if b.conn == nil {
+		return ratelimit.NewMemoryFailureStore()
+	}
+
+	return ratelimit.NewRedisFailureStore(b.conn, strings.Join([]string{redis.KeyPrefix(), "guard", name}, ":"))
+}
+
+func (b limitBackend) limiter(name string, limit ratelimit.Limit) ratelimit.Limiter {
+	if b.conn == nil {
+		return ratelimit.NewMemory(limit)
+	}
+
+	return ratelimit.NewRedis(b.conn, strings.Join([]string{redis.KeyPrefix(), "ratelimit", name}, ":"), limit)
+}
+
+// limitsConfigured tells whether rate limits or the brute-force guard
+// are enabled and therefore need a limit backend
+func limitsConfigured() bool {
+	return cfg.RateLimitCreate != "" || cfg.RateLimitRead != "" ||
+		cfg.BruteForceBanAfter > 0 || cfg.BruteForceDelayAfter > 0
+}
+
+// newRateLimiters creates the limiters for creating and reading
+// secrets, limiters without configured limit are nil
+func newRateLimiters(b limitBackend) (create, read ratelimit.Limiter, err error) {
+	for _, l := range []struct {
+		name    string
+		spec    string
+		limiter *ratelimit.Limiter
+	}{
+		{"create", cfg.RateLimitCreate, &create},
+		{"read", cfg.RateLimitRead, &read},
+	} {
+		if l.spec == "" {
+			continue
+		}
+
+		limit, err := ratelimit.ParseLimit(l.spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s limit: %w", l.name, err)
+		}
+
+		*l.limiter = b.limiter(l.name, limit)
+	}
+
+	return create, read, nil
+}
+
+// newReadGuard creates the guard against clients guessing secret IDs,
+// the guard is nil when neither delays nor bans are configured
+func newReadGuard(b limitBackend) *ratelimit.Guard {
+	if cfg.BruteForceBanAfter <= 0 && cfg.BruteForceDelayAfter <= 0 {
+		return nil
+	}
+
+	return ratelimit.NewGuard(ratelimit.GuardConfig{
+		BanAfter:    cfg.BruteForceBanAfter,
+		BanDuration: cfg.BruteForceBanDuration,
+		DelayAfter:  cfg.BruteForceDelayAfter,
+		Window:      cfg.BruteForceWindow,
+	}, b.failureStore("read"))
+}
+
+// guarded wraps read handlers to delay and ban clients producing many
+// reads of unknown secrets. Clients from the allowed subnets are never
+// delayed or banned.
+func (a apiServer) guarded(next http.HandlerFunc) http.HandlerFunc {
+	if a.readGuard == nil {
+		return next
+	}
+
+	return func(res http.ResponseWriter, r *http.Request) {
+		if requestInSubnetList(r, cust.BruteForceAllowedSubnets) {
+			next(res, r)
+			return
+		}
+
+		key := limitKey(r)
+
+		banned, delay, err := a.readGuard.Check(r.Context(), key)
+		if err != nil {
+			// Serving requests is preferred over failing them because of
+			// an unavailable guard
+			logrus.WithError(err).Error("checking brute-force guard")
+		}
+
+		if banned > 0 {
+			a.collector.CountRequestBlocked()
+			a.collector.CountSecretReadError(errorReasonClientBanned)
+			setRetryAfter(res, banned)
+			a.errorResponse(res, http.StatusTooManyRequests, errors.New("client banned"), "")
+			return
+		}
+
+		if delay > 0 {
+			a.collector.CountRequestDelayed()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status != http.StatusNotFound {
+			return
+		}
+
+		if banned, err := a.readGuard.Fail(r.Context(), key); err != nil {
+			logrus.WithError(err).Error("counting brute-force failure")
+		} else if banned {
+			a.collector.CountClientBanned()
+			logrus.WithFields(logrus.Fields{
+				"client":   key,
+				"duration": cfg.BruteForceBanDuration,
+			}).Warn("client banned for reading unknown secrets")
+		}
+	}
+}
+
+// rateLimited wraps handlers to reject requests exceeding the limit of
+// the client. Authenticated principals have their own bucket, all
+// other requests are limited by their IP.
+func (a apiServer) rateLimited(l ratelimit.Limiter, countError func(reason string)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if l == nil {
+			return next
+		}
+
+		return func(res http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := l.Allow(r.Context(), limitKey(r))
+			if err != nil {
+				// Serving requests is preferred over failing them because of
+				// an unavailable limiter
+				logrus.WithError(err).Error("checking rate limit")
+				ok = true
+			}
+
+			if !ok {
+				countError(errorReasonRateLimited)
+				setRetryAfter(res, retryAfter)
+				a.errorResponse(res, http.StatusTooManyRequests, errors.New("rate limit exceeded"), "")
+				return
+			}
+
+			next(res, r)
+		}
+	}
+}
+
+// limitKey identifies the client of the request by the name of the
+// authenticated principal or by its IP
+func limitKey(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p.name != "" {
+		return "principal:" + p.name
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+func setRetryAfter(res http.ResponseWriter, d time.Duration) {
+	res.Header().Set("Retry-After", strconv.FormatFloat(math.Ceil(d.Seconds()), 'f', 0, 64))
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
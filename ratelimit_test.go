@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/customization"
+	"github.com/Luzifer/ots/pkg/ratelimit"
+)
+
+func TestNewLimitBackend(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	for _, storageType := range []string{"bbolt", "file", "mem", "s3", "sql"} {
+		cfg.RateLimitRead = ""
+		cfg.RateLimitStorage = ""
+		cfg.StorageType = storageType
+
+		b, err := newLimitBackend()
+		require.NoError(t, err, "no limits configured")
+		assert.Nil(t, b.conn, storageType)
+
+		cfg.RateLimitRead = "10/1m"
+		_, err = newLimitBackend()
+		assert.Equal(t, sharedStorageTypes[storageType], err != nil, "storage must be chosen for shared %s storage", storageType)
+
+		cfg.RateLimitStorage = "mem"
+		b, err = newLimitBackend()
+		require.NoError(t, err, "explicit mem storage")
+		assert.Nil(t, b.conn, storageType)
+	}
+
+	cfg.RateLimitStorage = "foo"
+	_, err := newLimitBackend()
+	assert.Error(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	api, _ := newTestAPI(t)
+	api.createLimiter = ratelimit.NewMemory(ratelimit.Limit{Burst: 1, Interval: time.Minute})
+	api.readLimiter = ratelimit.NewMemory(ratelimit.Limit{Burst: 2, Interval: time.Minute})
+
+	cust.Auth.Tokens = []customization.Token{{Name: "ci", TokenHash: hashToken("ci-token")}}
+	cust.Auth.Anonymous = &customization.Policy{}
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	do := func(method, target, remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), method, target, strings.NewReader(`{"secret":"foo"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remote
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/create", "192.0.2.1:1234", "").Code)
+
+	res := do(http.MethodPost, "/api/create", "192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/create", "192.0.2.2:1234", "").Code, "clients must be limited separately")
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/create", "192.0.2.1:1234", "ci-token").Code, "principals must have their own bucket")
+
+	// Reading has a separate limit also counting unknown secrets
+	for range 2 {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/reveal/foo", "192.0.2.1:1234", "").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/get/foo", "192.0.2.1:1234", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/api/reveal/foo", "192.0.2.1:1234", "").Code)
+
+	// Other endpoints are not limited
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/isWritable", "192.0.2.1:1234", "").Code)
+}
+
+func TestBruteForceGuard(t *testing.T) {
+	api, _ := newTestAPI(t)
+	api.readGuard = ratelimit.NewGuard(ratelimit.GuardConfig{
+		BanAfter:    2,
+		BanDuration: time.Hour,
+		Window:      time.Minute,
+	}, ratelimit.NewMemoryFailureStore())
+
+	cust.BruteForceAllowedSubnets = []string{"198.51.100.0/24"}
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	do := func(method, target, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), method, target, strings.NewReader(`{"secret":"foo"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remote
+
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		return res
+	}
+
+	var resp apiResponse
+	res := do(http.MethodPost, "/api/create", "192.0.2.1:1234")
+	require.Equal(t, http.StatusCreated, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+
+	for range 2 {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/get/foo", "192.0.2.1:1234").Code)
+	}
+
+	res = do(http.MethodGet, "/api/get/"+resp.SecretID, "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code, "banned client must not read existing secrets")
+	assert.Equal(t, "3600", res.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/api/reveal/foo", "192.0.2.1:1234").Code)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/get/"+resp.SecretID, "192.0.2.2:1234").Code, "clients must be banned separately")
+
+	for range 3 {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/get/foo", "198.51.100.1:1234").Code, "allowed subnets must not be banned")
+	}
+}
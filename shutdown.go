@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/ots/pkg/storage"
+)
+
+// adminShutdownTimeout limits how long the admin server may take to
+// stop as it does not get any time left from the shutdown-timeout once
+// the draining of the requests used it up
+const adminShutdownTimeout = 5 * time.Second
+
+// serve serves HTTP or HTTPS (when the server has a TLSConfig) on the
+// listener until the server is shut down
+func serve(server *http.Server, listener net.Listener) (err error) {
+	if server.TLSConfig != nil {
+		// The certificate is provided through the TLSConfig
+		logrus.Infof("Starting HTTPS server on %s", listener.Addr())
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		logrus.Infof("Starting HTTP server on %s", listener.Addr())
+		err = server.Serve(listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return fmt.Errorf("serving: %w", err)
+}
+
+// shutdown stops the server gracefully: The health check reports the
+// instance unhealthy for the shutdown-delay, then the server stops
+// accepting connections and drains the in-flight requests within the
+// shutdown-timeout. The admin server (if any) is stopped afterwards
+// with its own timeout to report the shutdown until the end. The queued
+// webhooks are delivered within the remaining timeout, then the
+// storages are closed.
+func shutdown(server, admin *http.Server, api *apiServer, store storage.Storage, limits limitBackend) error {
+	api.beginShutdown()
+	time.Sleep(cfg.ShutdownDelay)
+
+	ctx := context.Background()
+	if cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownTimeout)
+		defer cancel()
+	}
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+	}
+
+	if admin != nil {
+		adminCtx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		defer cancel()
+
+		if err := admin.Shutdown(adminCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping admin server: %w", err))
+		}
+	}
+
+	if api.webhooks != nil {
+		if err := api.webhooks.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("delivering webhooks: %w", err))
+		}
+	}
+
+	// Requests still running after the timeout might fail from here
+	// but we need to release the storage anyway
+	if err := storage.Close(store); err != nil {
+		errs = append(errs, fmt.Errorf("closing storage: %w", err))
+	}
+
+	if err := limits.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing rate limit storage: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
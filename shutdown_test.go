@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/ots/pkg/webhook"
+)
+
+func TestShutdown(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.Listen = "127.0.0.1:0"
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	var delivered atomic.Int32
+	hooks := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		delivered.Add(1)
+	}))
+	t.Cleanup(hooks.Close)
+	api.webhooks = webhook.New("webhook-secret")
+
+	started := make(chan struct{})
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+	r.HandleFunc("/slow", func(res http.ResponseWriter, _ *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		api.webhooks.Send(hooks.URL, webhook.Event{Event: webhook.EventSecretRead, SecretID: "foo"})
+		res.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := listen()
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: r, ReadHeaderTimeout: time.Second}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(server, listener) }()
+
+	slowStatus := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+listener.Addr().String()+"/slow", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slowStatus <- 0
+			return
+		}
+		defer resp.Body.Close() //nolint:errcheck // Test
+
+		slowStatus <- resp.StatusCode
+	}()
+
+	<-started
+	require.NoError(t, shutdown(server, nil, api, store, limitBackend{}))
+
+	assert.Equal(t, http.StatusOK, <-slowStatus, "in-flight request must be drained")
+	assert.NoError(t, <-serveErr)
+	assert.Equal(t, int32(1), delivered.Load(), "queued webhooks must be delivered")
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err, "listener must be closed")
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/api/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+}
+
+func TestShutdownAdminAfterTimeout(t *testing.T) {
+	api, store := newTestAPI(t)
+	cfg.Listen = "127.0.0.1:0"
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+
+	serveSlow := func(d time.Duration, started chan struct{}) (*http.Server, chan int) {
+		server := &http.Server{
+			Handler: http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+				close(started)
+				time.Sleep(d)
+				res.WriteHeader(http.StatusOK)
+			}),
+			ReadHeaderTimeout: time.Second,
+		}
+
+		listener, err := listen()
+		require.NoError(t, err)
+		go func() { _ = serve(server, listener) }()
+
+		status := make(chan int, 1)
+		go func() {
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+listener.Addr().String()+"/", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				status <- 0
+				return
+			}
+			defer resp.Body.Close() //nolint:errcheck // Test
+
+			status <- resp.StatusCode
+		}()
+
+		return server, status
+	}
+
+	mainStarted, adminStarted := make(chan struct{}), make(chan struct{})
+	server, _ := serveSlow(time.Second, mainStarted)
+	admin, adminStatus := serveSlow(200*time.Millisecond, adminStarted)
+	<-mainStarted
+	<-adminStarted
+
+	err := shutdown(server, admin, api, store, limitBackend{})
+	require.ErrorIs(t, err, context.DeadlineExceeded, "draining must time out")
+	assert.NotContains(t, err.Error(), "stopping admin server", "admin server must get its own timeout")
+	assert.Equal(t, http.StatusOK, <-adminStatus, "admin request must be drained")
+}
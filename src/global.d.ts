@@ -4,8 +4,10 @@ declare global {
   interface Window {
     getTheme: () => string;
     getThemeFromStorage: () => string;
+    loginEnabled: boolean;
     maxSecretExpire: number;
     OTSCustomize: any;
+    otsUser: string;
     refreshTheme: () => void;
     setTheme: (theme: string) => void;
     useFormalLanguage: boolean;
@@ -16,6 +16,34 @@ function bytesToHuman(bytes: number): string {
   return `${bytes} B`
 }
 
+/**
+ * Reveals (and thereby consumes) the secret in two steps: The metadata
+ * is fetched without consuming the secret and contains the nonce to
+ * reveal it with. This way clients fetching URLs for previews do not
+ * consume secrets.
+ * @param {string} secretId ID of the secret to reveal
+ * @param {string} pin PIN of a PIN protected secret (empty if not known)
+ * @returns Promise<Response> Response of the failed metadata request or of the reveal request (status 403 for a missing or wrong PIN)
+ */
+function revealSecret(secretId: string, pin = ''): Promise<Response> {
+  return fetch(`api/reveal/${secretId}`)
+    .then(resp => {
+      if (resp.status !== 200) {
+        return resp
+      }
+
+      return resp.json()
+        .then(data => fetch(`api/reveal/${secretId}`, {
+          body: JSON.stringify({ pin: pin || undefined, reveal_nonce: data.reveal_nonce }),
+          headers: {
+            'content-type': 'application/json',
+          },
+          method: 'POST',
+        }))
+    })
+}
+
 export {
   bytesToHuman,
+  revealSecret,
 }
@@ -4,12 +4,37 @@ import (
 	"fmt"
 
 	"github.com/Luzifer/ots/pkg/storage"
+	"github.com/Luzifer/ots/pkg/storage/bbolt"
+	"github.com/Luzifer/ots/pkg/storage/file"
 	"github.com/Luzifer/ots/pkg/storage/memory"
 	"github.com/Luzifer/ots/pkg/storage/redis"
+	"github.com/Luzifer/ots/pkg/storage/s3"
+	"github.com/Luzifer/ots/pkg/storage/sql"
 )
 
+// sharedStorageTypes can be used by multiple instances at once
+var sharedStorageTypes = map[string]bool{
+	"redis": true,
+	"s3":    true,
+	"sql":   true,
+}
+
 func getStorageByType(t string) (storage.Storage, error) {
 	switch t {
+	case "bbolt":
+		s, err := bbolt.New()
+		if err != nil {
+			return s, fmt.Errorf("creating bbolt storage: %w", err)
+		}
+		return s, nil
+
+	case "file":
+		s, err := file.New()
+		if err != nil {
+			return s, fmt.Errorf("creating file storage: %w", err)
+		}
+		return s, nil
+
 	case "mem":
 		return memory.New(), nil
 
@@ -20,6 +45,20 @@ func getStorageByType(t string) (storage.Storage, error) {
 		}
 		return s, nil
 
+	case "s3":
+		s, err := s3.New()
+		if err != nil {
+			return s, fmt.Errorf("creating s3 storage: %w", err)
+		}
+		return s, nil
+
+	case "sql":
+		s, err := sql.New()
+		if err != nil {
+			return s, fmt.Errorf("creating sql storage: %w", err)
+		}
+		return s, nil
+
 	default:
 		return nil, fmt.Errorf("storage type %q not found", t)
 	}
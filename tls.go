@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	clientCertModeAll    = "all"
+	clientCertModeCreate = "create"
+	clientCertModeNone   = "none"
+
+	// certCheckInterval is the interval the certificate files are
+	// checked for changes in
+	certCheckInterval = 30 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader holds the server certificate and replaces it when the
+// files changed or a SIGHUP is received
+type certReloader struct {
+	certFile, keyFile string
+
+	cert    *tls.Certificate
+	modTime time.Time
+	lock    sync.RWMutex
+}
+
+// newTLSConfig creates the TLS configuration for the server from the
+// cli options. The certificate is reloaded in the background until
+// the context is cancelled.
+func newTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("TLS is enabled but cert-file or key-file is not provided")
+	}
+
+	reloader := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	go reloader.watch(ctx)
+
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", cfg.TLSMinVersion)
+	}
+
+	cipherSuites, err := parseCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		CipherSuites:   cipherSuites,
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     minVersion,
+	}
+
+	switch cfg.ClientCertMode {
+	case clientCertModeNone:
+		return tlsConfig, nil
+
+	case clientCertModeAll:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	case clientCertModeCreate:
+		// Certificates are verified when given and required by
+		// checkCreateAllowed for creating secrets
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	default:
+		return nil, fmt.Errorf("unknown client-cert mode %q", cfg.ClientCertMode)
+	}
+
+	if cfg.ClientCAFile == "" {
+		return nil, errors.New("client certificates are enabled but client-ca-file is not provided")
+	}
+
+	ca, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("client CA does not contain any certificate")
+	}
+
+	return tlsConfig, nil
+}
+
+// parseCipherSuites converts the names of the cipher suites into their
+// IDs, only suites without known security issues are accepted
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		// Go defaults
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// clientCertMissing tells whether the request needs to present a
+// verified client certificate to create secrets and did not
+func clientCertMissing(r *http.Request) bool {
+	if !cfg.EnableTLS || cfg.ClientCertMode != clientCertModeCreate {
+		// In "all" mode the handshake already failed without one
+		return false
+	}
+
+	return r.TLS == nil || len(r.TLS.VerifiedChains) == 0
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.cert, nil
+}
+
+// reload loads the certificate and key from disk
+func (c *certReloader) reload() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.cert = &cert
+	c.modTime = modTime
+
+	return nil
+}
+
+// changed tells whether the files have been modified since the last
+// reload. Errors are reported when reloading as the files might be in
+// the middle of being replaced.
+func (c *certReloader) changed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	modTime, err := c.filesModTime()
+	return err == nil && modTime.After(c.modTime)
+}
+
+// filesModTime returns the latest modification of the files
+func (c *certReloader) filesModTime() (t time.Time, err error) {
+	for _, fn := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(fn)
+		if err != nil {
+			return t, fmt.Errorf("getting file info: %w", err)
+		}
+
+		if info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+
+	return t, nil
+}
+
+// watch reloads the certificate on SIGHUP and when the files have
+// been modified. Polling is used as the files are usually replaced
+// through symlinks (i.e. Kubernetes secrets) which are hard to watch.
+func (c *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	t := time.NewTicker(certCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+
+		case <-t.C:
+			if !c.changed() {
+				continue
+			}
+		}
+
+		if err := c.reload(); err != nil {
+			// The old certificate is kept until the new one is valid
+			logrus.WithError(err).Error("reloading TLS certificate")
+			continue
+		}
+
+		logrus.Info("TLS certificate reloaded")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	tls  tls.Certificate
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ids)
+
+	ids, err = parseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, ids)
+
+	_, err = parseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.Error(t, err, "insecure suites must be rejected")
+}
+
+func TestCertReloader(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile  = filepath.Join(dir, "tls.key")
+	)
+
+	writeTestCert(t, newTestCert(t, "first", nil), certFile, keyFile)
+
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	require.NoError(t, c.reload())
+	assert.False(t, c.changed())
+
+	second := newTestCert(t, "second", nil)
+	writeTestCert(t, second, certFile, keyFile)
+
+	// Ensure the modification is visible on filesystems with a coarse
+	// time resolution
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	assert.True(t, c.changed())
+	require.NoError(t, c.reload())
+
+	cert, err := c.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.tls.Certificate, cert.Certificate)
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0o600))
+	assert.Error(t, c.reload())
+
+	cert, err = c.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.tls.Certificate, cert.Certificate, "old certificate must be kept")
+}
+
+func TestClientCertForCreate(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile  = filepath.Join(dir, "tls.key")
+		caFile   = filepath.Join(dir, "ca.crt")
+
+		ca     = newTestCert(t, "ca", nil)
+		server = newTestCert(t, "127.0.0.1", nil)
+		client = newTestCert(t, "client", ca)
+	)
+
+	writeTestCert(t, server, certFile, keyFile)
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	api, _ := newTestAPI(t)
+	cfg.EnableTLS = true
+	cfg.CertFile, cfg.KeyFile = certFile, keyFile
+	cfg.ClientCAFile = caFile
+	cfg.ClientCertMode = clientCertModeCreate
+	cfg.TLSMinVersion = "1.2"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	tlsConfig, err := newTLSConfig(ctx)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	api.Register(r.PathPrefix("/api").Subrouter())
+
+	cfg.Listen = "127.0.0.1:0"
+	listener, err := listen()
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: r, ReadHeaderTimeout: time.Second, TLSConfig: tlsConfig}
+	go func() { _ = serve(srv, listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.cert)
+
+	do := func(method, path string, certs ...tls.Certificate) int {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: certs,
+			MinVersion:   tls.VersionTLS12,
+			RootCAs:      roots,
+		}}}
+
+		req, err := http.NewRequestWithContext(context.Background(), method, "https://"+listener.Addr().String()+path, strings.NewReader(`{"secret":"foo"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck // Test
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/create"))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/isWritable"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/get/foo"), "reads must not need a certificate")
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/create", client.tls))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/isWritable", client.tls))
+
+	cfg.ClientCertMode = "foo"
+	_, err = newTLSConfig(ctx)
+	assert.Error(t, err)
+}
+
+// newTestCert creates a certificate signed by the parent or a self
+// signed CA if there is no parent
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		tpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	tlsCert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key, pem: certPEM, tls: tlsCert}
+}
+
+func writeTestCert(t *testing.T, c *testCert, certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, c.pem, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}